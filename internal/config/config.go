@@ -1,6 +1,8 @@
 package config
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"time"
 
@@ -15,6 +17,8 @@ type Config struct {
         User        string `yaml:"user"`
         Password    string `yaml:"password"`
         RestorePath string `yaml:"restore_path"` // /var/opt/mssql/data
+        DataPath    string `yaml:"data_path"`    // Каталог для файлов данных (если пусто - restore_path)
+        LogPath     string `yaml:"log_path"`     // Каталог для файлов журнала (если пусто - restore_path)
     } `yaml:"mssql"`
     SMBShare struct {
         RemotePath      string `yaml:"remote_path"`
@@ -25,8 +29,77 @@ type Config struct {
         LogFile     string   `yaml:"log_file"`
         LogLevel    string   `yaml:"log_level"`
         BackupBlacklist []string `yaml:"backup_blacklist"` // Черный список бэкапов
+        MaxConcurrentJobs int `yaml:"max_concurrent_jobs"` // Максимум одновременных операций (бэкап/восстановление), 0 - по умолчанию (4)
+        MaxJobsPerServer  int `yaml:"max_jobs_per_server"` // Максимум одновременных операций на один сервер SQL, 0 - по умолчанию (2)
+        IndexInterval string `yaml:"index_interval"` // Период обхода SMB-шары индексатором каталога бэкапов ("15m"), "0" - отключить
+        IndexWorkers  int    `yaml:"index_workers"`  // Число одновременно читаемых заголовков бэкапов, 0 - по умолчанию (2)
     } `yaml:"app"`
+    MSDBImport struct {
+        Server       string        `yaml:"server"`        // Сервер-источник истории бэкапов (пусто - сервер из mssql)
+        Port         int           `yaml:"port"`          // 0 - порт из mssql
+        User         string        `yaml:"user"`          // Пусто - пользователь и пароль из mssql
+        Password     string        `yaml:"password"`
+        PathRewrites []PathRewrite `yaml:"path_rewrites"` // Замена префиксов physical_device_name на пути в точке монтирования
+    } `yaml:"msdb_import"`
     Whitelist []string `yaml:"whitelist"` // Белый список IP-адресов
+    Schedules []Schedule `yaml:"schedules,omitempty"` // Расписания бэкапов
+    Retention struct {
+        Default     RetentionPolicy            `yaml:"default"`     // Политика для всех каталогов бэкапов
+        Directories map[string]RetentionPolicy `yaml:"directories"` // Политики отдельных каталогов (заменяют default)
+    } `yaml:"retention"`
+
+    path string // Путь к файлу конфигурации (для сохранения изменений через API)
+}
+
+// Политики для запусков расписания, пропущенных во время простоя службы
+const (
+    MissedRunSkip    = "skip"     // Пропустить (по умолчанию)
+    MissedRunCatchUp = "catch_up" // Выполнить один раз сразу после запуска службы
+)
+
+// Действия, выполняемые по расписанию
+const (
+    ScheduleActionBackup = "backup" // Создание бэкапа (по умолчанию)
+    ScheduleActionVerify = "verify" // Проверка цепочки бэкапов каталога (RESTORE VERIFYONLY)
+    ScheduleActionRestoreTest = "restore_test" // Тестовое восстановление каталога с DBCC CHECKDB
+)
+
+// Schedule - Расписание бэкапов по cron-выражению для базы или шаблона имен баз
+type Schedule struct {
+    ID           string `yaml:"id" json:"id"`
+    Cron         string `yaml:"cron" json:"cron"`                                     // Cron-выражение (5 полей или @daily, @hourly и т.п.)
+    Action       string `yaml:"action,omitempty" json:"action,omitempty"`             // backup, verify или restore_test (для verify и restore_test database/pattern - каталоги бэкапов)
+    Database     string `yaml:"database,omitempty" json:"database,omitempty"`         // Имя базы данных
+    Pattern      string `yaml:"pattern,omitempty" json:"pattern,omitempty"`           // Шаблон имен баз (например, "dev_*")
+    Type         string `yaml:"type,omitempty" json:"type,omitempty"`                 // full, diff, log (по умолчанию full)
+    Mode         string `yaml:"mode,omitempty" json:"mode,omitempty"`                 // online, exclusive (по умолчанию online)
+    CopyOnly     bool   `yaml:"copy_only,omitempty" json:"copyOnly,omitempty"`        // Бэкап COPY_ONLY
+    Compression  *bool  `yaml:"compression,omitempty" json:"compression,omitempty"`   // Сжатие (по умолчанию - настройка сервера)
+    Checksum     *bool  `yaml:"checksum,omitempty" json:"checksum,omitempty"`         // Контрольные суммы (по умолчанию - настройка сервера)
+    Description  string `yaml:"description,omitempty" json:"description,omitempty"`   // Описание набора бэкапа
+    MissedPolicy string `yaml:"missed_policy,omitempty" json:"missedPolicy,omitempty"` // skip или catch_up
+    Disabled     bool   `yaml:"disabled,omitempty" json:"disabled,omitempty"`         // Расписание отключено
+}
+
+// RetentionPolicy - Правила хранения бэкапов в каталоге. Файл сохраняется, если его оставляет хотя бы одно правило;
+// нулевые значения отключают правило, политика без правил ничего не удаляет.
+type RetentionPolicy struct {
+    KeepChains  int `yaml:"keep_chains,omitempty" json:"keepChains,omitempty"`   // Последние N полных цепочек (полный + дифф. + журналы)
+    KeepDays    int `yaml:"keep_days,omitempty" json:"keepDays,omitempty"`       // Цепочки, покрывающие последние X дней
+    KeepWeekly  int `yaml:"keep_weekly,omitempty" json:"keepWeekly,omitempty"`   // GFS: первый полный бэкап каждой из последних N недель
+    KeepMonthly int `yaml:"keep_monthly,omitempty" json:"keepMonthly,omitempty"` // GFS: первый полный бэкап каждого из последних N месяцев
+}
+
+// IsEmpty - Проверяет, что в политике не задано ни одного правила
+func (p RetentionPolicy) IsEmpty() bool {
+    return p.KeepChains <= 0 && p.KeepDays <= 0 && p.KeepWeekly <= 0 && p.KeepMonthly <= 0
+}
+
+// PathRewrite - Правило замены префикса пути к файлу бэкапа на сервере-источнике (\\server\share\... или D:\Backup\...)
+// на путь в локальной точке монтирования SMB-шары. Сравнение без учета регистра и вида разделителей.
+type PathRewrite struct {
+    From string `yaml:"from" json:"from"`
+    To   string `yaml:"to" json:"to"`
 }
 
 // Структура для отображения базы данных в веб-интерфейсе
@@ -47,6 +120,74 @@ type LogEntry struct {
 	Message   string    `json:"message"`
 }
 
+// RestoreDataPath - Каталог для файлов данных при восстановлении (data_path или restore_path)
+func (c *Config) RestoreDataPath() string {
+	if c.MSSQL.DataPath != "" {
+		return c.MSSQL.DataPath
+	}
+	return c.MSSQL.RestorePath
+}
+
+// RestoreLogPath - Каталог для файлов журнала при восстановлении (log_path или restore_path)
+func (c *Config) RestoreLogPath() string {
+	if c.MSSQL.LogPath != "" {
+		return c.MSSQL.LogPath
+	}
+	return c.MSSQL.RestorePath
+}
+
+// Значения индексатора каталога бэкапов по умолчанию
+const (
+    DefaultIndexInterval = 15 * time.Minute
+    DefaultIndexWorkers  = 2
+)
+
+// CatalogIndexInterval - Период обхода SMB-шары индексатором (0 - индексатор отключен)
+func (c *Config) CatalogIndexInterval() (time.Duration, error) {
+	if c.App.IndexInterval == "" {
+		return DefaultIndexInterval, nil
+	}
+	if c.App.IndexInterval == "0" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(c.App.IndexInterval)
+	if err != nil || interval < time.Minute {
+		return DefaultIndexInterval, fmt.Errorf("неверный период индексации '%s' (ожидается, например, 15m, не меньше 1m)", c.App.IndexInterval)
+	}
+	return interval, nil
+}
+
+// CatalogIndexWorkers - Число одновременно читаемых индексатором заголовков бэкапов
+func (c *Config) CatalogIndexWorkers() int {
+	if c.App.IndexWorkers > 0 {
+		return c.App.IndexWorkers
+	}
+	return DefaultIndexWorkers
+}
+
+// MSDBImportEnabled - Задан ли раздел msdb_import: тогда и фоновый индексатор берет наборы бэкапов из истории msdb
+func (c *Config) MSDBImportEnabled() bool {
+	return c.MSDBImport.Server != "" || len(c.MSDBImport.PathRewrites) > 0
+}
+
+// MSDBPathRewrites - Правила замены путей для импорта истории из msdb: заданные в msdb_import.path_rewrites
+// и в конце - remote_path SMB-шары на local_mount_point
+func (c *Config) MSDBPathRewrites() []PathRewrite {
+	rewrites := append([]PathRewrite{}, c.MSDBImport.PathRewrites...)
+	if c.SMBShare.RemotePath != "" && c.SMBShare.LocalMountPoint != "" {
+		rewrites = append(rewrites, PathRewrite{From: c.SMBShare.RemotePath, To: c.SMBShare.LocalMountPoint})
+	}
+	return rewrites
+}
+
+// RetentionFor - Политика хранения для каталога бэкапов (собственная или default)
+func (c *Config) RetentionFor(dirName string) RetentionPolicy {
+	if policy, ok := c.Retention.Directories[dirName]; ok {
+		return policy
+	}
+	return c.Retention.Default
+}
+
 // Загружает конфигурацию из файла
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -58,6 +199,53 @@ func LoadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+	config.path = path
 	
 	return &config, nil
 }
+
+// SaveSchedules - Записывает раздел schedules в файл конфигурации, сохраняя остальные разделы и комментарии
+func (c *Config) SaveSchedules() error {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("неожиданная структура файла конфигурации %s", c.path)
+	}
+
+	var value yaml.Node
+	if err := value.Encode(c.Schedules); err != nil {
+		return err
+	}
+
+	mapping := root.Content[0]
+	replaced := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "schedules" {
+			mapping.Content[i+1] = &value
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "schedules"}, &value)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+
+	return os.WriteFile(c.path, buf.Bytes(), 0600)
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/freezzorg/SQLManager/internal/utils"
+)
+
+// Названия версий SQL Server по основному номеру версии (SoftwareVersionMajor)
+var sqlServerVersionNames = map[int]string{
+	9:  "2005",
+	10: "2008",
+	11: "2012",
+	12: "2014",
+	13: "2016",
+	14: "2017",
+	15: "2019",
+	16: "2022",
+	17: "2025",
+}
+
+// sqlServerVersionName - Возвращает название версии SQL Server (например, "SQL Server 2019 (15)")
+func sqlServerVersionName(major int) string {
+	if name, ok := sqlServerVersionNames[major]; ok {
+		return fmt.Sprintf("SQL Server %s (%d)", name, major)
+	}
+	return fmt.Sprintf("SQL Server %d", major)
+}
+
+// getServerMajorVersion - Возвращает основную версию целевого SQL Server
+func getServerMajorVersion(db *sql.DB) (int, error) {
+	var major int
+	if err := db.QueryRow("SELECT @@MICROSOFTVERSION / 0x01000000").Scan(&major); err != nil {
+		return 0, fmt.Errorf("ошибка получения версии SQL Server: %w", err)
+	}
+	return major, nil
+}
+
+// expectedDatabaseName - Определяет, бэкапы какой базы должны быть в каталоге: базы с именем каталога,
+// если такие бэкапы есть, иначе базы последнего полного бэкапа (пусто, если имена баз неизвестны)
+func expectedDatabaseName(dirName string, metadata []BackupMetadata) string {
+	var latestFull *BackupMetadata
+	for i, b := range metadata {
+		if strings.EqualFold(b.DatabaseName, dirName) {
+			return b.DatabaseName
+		}
+		if b.Type == "Database" && b.DatabaseName != "" && (latestFull == nil || b.End.AfterCT(latestFull.End)) {
+			latestFull = &metadata[i]
+		}
+	}
+	if latestFull != nil {
+		return latestFull.DatabaseName
+	}
+	return ""
+}
+
+// filterBackupsByDatabase - Оставляет бэкапы нужной базы каталога.
+// Записи без имени базы (метаданные прежних версий) не отбрасываются.
+func filterBackupsByDatabase(dirName string, metadata []BackupMetadata) []BackupMetadata {
+	expected := expectedDatabaseName(dirName, metadata)
+	if expected == "" {
+		return metadata
+	}
+	var result []BackupMetadata
+	for _, b := range metadata {
+		if b.DatabaseName == "" || strings.EqualFold(b.DatabaseName, expected) {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// sameBackupFamily - Проверяет, что бэкапы относятся к одному экземпляру базы (FamilyGUID).
+// После пересоздания базы с тем же именем LSN могут совпасть случайно, поэтому FamilyGUID сравнивается отдельно.
+func sameBackupFamily(a, b BackupMetadata) bool {
+	return a.FamilyGUID == "" || b.FamilyGUID == "" || strings.EqualFold(a.FamilyGUID, b.FamilyGUID)
+}
+
+// checkBackupVersion - Проверяет, что бэкап сделан на версии SQL Server не новее целевого сервера
+func checkBackupVersion(b BackupMetadata, serverMajor int) error {
+	if b.SoftwareVersionMajor == 0 || serverMajor == 0 || b.SoftwareVersionMajor <= serverMajor {
+		return nil
+	}
+	return fmt.Errorf("бэкап %s сделан на %s, целевой сервер - %s: восстановление на более старую версию невозможно",
+		b.FileName, sqlServerVersionName(b.SoftwareVersionMajor), sqlServerVersionName(serverMajor))
+}
+
+// GetBackupWarnings - Возвращает предупреждения о содержимом каталога бэкапов: бэкапы другой базы
+// или другого экземпляра базы, бэкапы более новой версии SQL Server, чем целевой сервер, поврежденные бэкапы
+func GetBackupWarnings(db *sql.DB, dirName, smbSharePath string) ([]string, error) {
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+	metadata, err := loadBackupMetadata(filepath.Join(smbSharePath, dirName))
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := []string{}
+	expected := expectedDatabaseName(dirName, metadata)
+	otherDatabases := make(map[string]int)
+	families := make(map[string]bool)
+	var newer, damaged []string
+	serverMajor, err := getServerMajorVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range metadata {
+		if expected != "" && b.DatabaseName != "" && !strings.EqualFold(b.DatabaseName, expected) {
+			otherDatabases[b.DatabaseName]++
+			continue
+		}
+		if b.FamilyGUID != "" {
+			families[strings.ToUpper(b.FamilyGUID)] = true
+		}
+		if checkBackupVersion(b, serverMajor) != nil {
+			newer = append(newer, b.FileName)
+		}
+		if b.IsDamaged {
+			damaged = append(damaged, b.FileName)
+		}
+	}
+
+	if len(otherDatabases) > 0 {
+		names := make([]string, 0, len(otherDatabases))
+		for name, count := range otherDatabases {
+			names = append(names, fmt.Sprintf("%s (%d)", name, count))
+		}
+		sort.Strings(names)
+		warnings = append(warnings, fmt.Sprintf("В каталоге есть бэкапы других баз, они не используются при восстановлении %s: %s",
+			expected, strings.Join(names, ", ")))
+	}
+	if len(families) > 1 {
+		warnings = append(warnings, fmt.Sprintf("В каталоге бэкапы %d разных экземпляров базы %s (база пересоздавалась): цепочка строится только из бэкапов одного экземпляра",
+			len(families), expected))
+	}
+	if len(newer) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Бэкапы сделаны на более новой версии SQL Server, чем целевой сервер (%s), и не могут быть восстановлены: %s",
+			sqlServerVersionName(serverMajor), strings.Join(newer, ", ")))
+	}
+	if len(damaged) > 0 {
+		warnings = append(warnings, fmt.Sprintf("Бэкапы помечены как поврежденные: %s", strings.Join(damaged, ", ")))
+	}
+	return warnings, nil
+}
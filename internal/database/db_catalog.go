@@ -0,0 +1,602 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Имя файла метаданных в каталоге бэкапов (экспорт каталога для совместимости с существующими шарами)
+const metadataFileName = "backup_metadata.json"
+
+// Bucket'ы каталога: записи о файлах бэкапов (ключ "каталог/файл") и сведения о каталогах бэкапов
+var (
+	catalogBackupsBucket     = []byte("backups")
+	catalogDirectoriesBucket = []byte("directories")
+)
+
+// CatalogEntry - Запись каталога о файле бэкапа
+type CatalogEntry struct {
+	Directory string `json:"Directory"` // Каталог бэкапов на SMB-шаре
+	Database  string `json:"Database"`  // Имя базы данных
+	BackupMetadata
+	Size      int64      `json:"Size"`      // Размер файла в байтах
+	ModTime   CustomTime `json:"ModTime"`   // Время изменения файла
+	IndexTime CustomTime `json:"IndexTime"` // Время занесения записи в каталог
+}
+
+// CatalogDirectory - Сведения о каталоге бэкапов в каталоге
+type CatalogDirectory struct {
+	Name       string     `json:"name"`
+	ImportTime *time.Time `json:"importTime,omitempty"` // Время импорта backup_metadata.json
+	ExportTime *time.Time `json:"exportTime,omitempty"` // Время последнего экспорта в backup_metadata.json
+}
+
+// CatalogFilter - Условия выборки записей каталога (пустые поля не ограничивают выборку)
+type CatalogFilter struct {
+	Directory string
+	Database  string
+	Type      string     // Database, Database Differential, Transaction Log
+	From      *time.Time // Окончание бэкапа не раньше
+	To        *time.Time // Окончание бэкапа не позже
+}
+
+var catalog *bolt.DB
+var catalogMutex sync.Mutex
+
+// Экспорт в backup_metadata.json выполняется по одному, чтобы файлы не перезаписывались одновременно
+var catalogExportMutex sync.Mutex
+
+// SetupCatalog - Открывает (или создает) файл каталога бэкапов
+func SetupCatalog(path string) error {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("ошибка открытия каталога бэкапов %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{catalogBackupsBucket, catalogDirectoriesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return fmt.Errorf("ошибка инициализации каталога бэкапов %s: %w", path, err)
+	}
+
+	catalog = db
+	return nil
+}
+
+// CloseCatalog - Закрывает каталог бэкапов
+func CloseCatalog() error {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+
+	if catalog == nil {
+		return nil
+	}
+	err := catalog.Close()
+	catalog = nil
+	return err
+}
+
+// getCatalog - Возвращает открытый каталог (nil, если каталог не открыт - тогда используется backup_metadata.json)
+func getCatalog() *bolt.DB {
+	catalogMutex.Lock()
+	defer catalogMutex.Unlock()
+	return catalog
+}
+
+// catalogKey - Ключ записи каталога ("каталог/файл", для второго и следующих наборов в файле - "каталог/файл#n")
+func catalogKey(dirName string, metadata BackupMetadata) []byte {
+	return []byte(dirName + "/" + metadata.catalogEntryKey())
+}
+
+// catalogPrefix - Префикс ключей записей каталога бэкапов
+func catalogPrefix(dirName string) []byte {
+	return []byte(dirName + "/")
+}
+
+// newCatalogEntry - Формирует запись каталога по метаданным файла бэкапа (размер и время изменения - с диска)
+func newCatalogEntry(backupDir string, metadata BackupMetadata) CatalogEntry {
+	dirName := filepath.Base(backupDir)
+	entry := CatalogEntry{
+		Directory:      dirName,
+		Database:       metadata.DatabaseName,
+		BackupMetadata: metadata,
+		IndexTime:      CustomTime{time.Now()},
+	}
+	if entry.Database == "" {
+		// Метаданные прежних версий без имени базы: каталог называется по имени базы
+		entry.Database = dirName
+	}
+	if info, err := os.Stat(filepath.Join(backupDir, metadata.FileName)); err == nil {
+		entry.Size = info.Size()
+		entry.ModTime = CustomTime{info.ModTime()}
+	}
+	return entry
+}
+
+// readCatalogEntries - Читает записи каталога бэкапов в транзакции
+func readCatalogEntries(tx *bolt.Tx, dirName string) ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+	prefix := catalogPrefix(dirName)
+	cursor := tx.Bucket(catalogBackupsBucket).Cursor()
+	for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+		var entry CatalogEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return nil, fmt.Errorf("ошибка разбора записи каталога %s: %w", string(k), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readCatalogDirectory - Читает сведения о каталоге бэкапов (nil, если каталог еще не заносился)
+func readCatalogDirectory(tx *bolt.Tx, dirName string) (*CatalogDirectory, error) {
+	data := tx.Bucket(catalogDirectoriesBucket).Get([]byte(dirName))
+	if data == nil {
+		return nil, nil
+	}
+	dir := &CatalogDirectory{}
+	if err := json.Unmarshal(data, dir); err != nil {
+		return nil, fmt.Errorf("ошибка разбора сведений о каталоге %s: %w", dirName, err)
+	}
+	return dir, nil
+}
+
+// writeCatalogDirectory - Сохраняет сведения о каталоге бэкапов
+func writeCatalogDirectory(tx *bolt.Tx, dir *CatalogDirectory) error {
+	data, err := json.Marshal(dir)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(catalogDirectoriesBucket).Put([]byte(dir.Name), data)
+}
+
+// putCatalogEntry - Сохраняет запись каталога в транзакции
+func putCatalogEntry(tx *bolt.Tx, entry CatalogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации записи каталога %s: %w", entry.FileName, err)
+	}
+	return tx.Bucket(catalogBackupsBucket).Put(catalogKey(entry.Directory, entry.BackupMetadata), data)
+}
+
+// sortBackupMetadata - Сортирует метаданные по времени начала бэкапа
+func sortBackupMetadata(metadata []BackupMetadata) {
+	sort.Slice(metadata, func(i, j int) bool {
+		return metadata[i].Start.Before(metadata[j].Start.Time)
+	})
+}
+
+// readMetadataJSON - Читает backup_metadata.json каталога бэкапов (nil, если файла нет)
+func readMetadataJSON(backupDir string) ([]BackupMetadata, error) {
+	metadataPath := filepath.Join(backupDir, metadataFileName)
+	data, err := os.ReadFile(metadataPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла метаданных %s: %w", metadataPath, err)
+	}
+
+	var metadata []BackupMetadata
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("ошибка парсинга файла метаданных %s: %w", metadataPath, err)
+		}
+	}
+	return metadata, nil
+}
+
+// writeMetadataJSON - Записывает backup_metadata.json каталога бэкапов через временный файл,
+// чтобы читатели никогда не видели частично записанный файл
+func writeMetadataJSON(backupDir string, metadata []BackupMetadata) error {
+	sortBackupMetadata(metadata)
+	if metadata == nil {
+		metadata = []BackupMetadata{}
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации метаданных в JSON: %w", err)
+	}
+
+	metadataPath := filepath.Join(backupDir, metadataFileName)
+	tmpPath := metadataPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("ошибка записи файла метаданных %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, metadataPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("ошибка записи файла метаданных %s: %w", metadataPath, err)
+	}
+	return nil
+}
+
+// importMetadataJSON - Заменяет записи каталога бэкапов содержимым backup_metadata.json
+func importMetadataJSON(cat *bolt.DB, backupDir string) (int, error) {
+	metadata, err := readMetadataJSON(backupDir)
+	if err != nil {
+		return 0, err
+	}
+	dirName := filepath.Base(backupDir)
+	entries := make([]CatalogEntry, 0, len(metadata))
+	for _, m := range metadata {
+		entries = append(entries, newCatalogEntry(backupDir, m))
+	}
+
+	err = cat.Update(func(tx *bolt.Tx) error {
+		existing, err := readCatalogEntries(tx, dirName)
+		if err != nil {
+			return err
+		}
+		for _, entry := range existing {
+			if err := tx.Bucket(catalogBackupsBucket).Delete(catalogKey(dirName, entry.BackupMetadata)); err != nil {
+				return err
+			}
+		}
+		for _, entry := range entries {
+			if err := putCatalogEntry(tx, entry); err != nil {
+				return err
+			}
+		}
+		dir, err := readCatalogDirectory(tx, dirName)
+		if err != nil {
+			return err
+		}
+		if dir == nil {
+			dir = &CatalogDirectory{Name: dirName}
+		}
+		now := time.Now()
+		dir.ImportTime = &now
+		return writeCatalogDirectory(tx, dir)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("ошибка импорта метаданных каталога '%s': %w", dirName, err)
+	}
+	return len(entries), nil
+}
+
+// ensureCatalogDirectory - При первом обращении к каталогу бэкапов переносит его backup_metadata.json в каталог
+func ensureCatalogDirectory(cat *bolt.DB, backupDir string) error {
+	dirName := filepath.Base(backupDir)
+	var known bool
+	if err := cat.View(func(tx *bolt.Tx) error {
+		dir, err := readCatalogDirectory(tx, dirName)
+		known = dir != nil
+		return err
+	}); err != nil {
+		return err
+	}
+	if known {
+		return nil
+	}
+
+	count, err := importMetadataJSON(cat, backupDir)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logging.LogInfo(fmt.Sprintf("Метаданные каталога '%s' перенесены из %s в каталог бэкапов, записей: %d", dirName, metadataFileName, count))
+	}
+	return nil
+}
+
+// exportMetadataJSON - Выгружает записи каталога бэкапов в backup_metadata.json
+func exportMetadataJSON(cat *bolt.DB, backupDir string) (int, error) {
+	catalogExportMutex.Lock()
+	defer catalogExportMutex.Unlock()
+
+	dirName := filepath.Base(backupDir)
+	var metadata []BackupMetadata
+	if err := cat.View(func(tx *bolt.Tx) error {
+		entries, err := readCatalogEntries(tx, dirName)
+		for _, entry := range entries {
+			metadata = append(metadata, entry.BackupMetadata)
+		}
+		return err
+	}); err != nil {
+		return 0, err
+	}
+	if err := writeMetadataJSON(backupDir, metadata); err != nil {
+		return 0, err
+	}
+
+	err := cat.Update(func(tx *bolt.Tx) error {
+		dir, err := readCatalogDirectory(tx, dirName)
+		if err != nil {
+			return err
+		}
+		if dir == nil {
+			dir = &CatalogDirectory{Name: dirName}
+		}
+		now := time.Now()
+		dir.ExportTime = &now
+		return writeCatalogDirectory(tx, dir)
+	})
+	return len(metadata), err
+}
+
+// catalogChanged - Выгружает каталог бэкапов в backup_metadata.json после изменения (ошибка только логируется)
+func catalogChanged(cat *bolt.DB, backupDir string) {
+	if _, err := exportMetadataJSON(cat, backupDir); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка экспорта метаданных каталога '%s' в %s: %v", filepath.Base(backupDir), metadataFileName, err))
+	}
+}
+
+// loadBackupMetadata - Возвращает метаданные файлов каталога бэкапов (пустой список, если их нет).
+// Если каталог бэкапов не открыт, читается backup_metadata.json.
+func loadBackupMetadata(backupDir string) ([]BackupMetadata, error) {
+	cat := getCatalog()
+	if cat == nil {
+		metadata, err := readMetadataJSON(backupDir)
+		if err != nil {
+			return nil, err
+		}
+		return groupStripeSets(metadata), nil
+	}
+	if err := ensureCatalogDirectory(cat, backupDir); err != nil {
+		return nil, err
+	}
+
+	var metadata []BackupMetadata
+	err := cat.View(func(tx *bolt.Tx) error {
+		entries, err := readCatalogEntries(tx, filepath.Base(backupDir))
+		for _, entry := range entries {
+			metadata = append(metadata, entry.BackupMetadata)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupStripeSets(metadata), nil
+}
+
+// deleteCatalogFiles - Удаляет в транзакции записи обо всех наборах бэкапа в указанных файлах и возвращает их
+func deleteCatalogFiles(tx *bolt.Tx, dirName string, fileNames []string) (map[string]CatalogEntry, error) {
+	entries, err := readCatalogEntries(tx, dirName)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]CatalogEntry)
+	for _, entry := range entries {
+		if !containsString(fileNames, entry.FileName) {
+			continue
+		}
+		if err := tx.Bucket(catalogBackupsBucket).Delete(catalogKey(dirName, entry.BackupMetadata)); err != nil {
+			return nil, err
+		}
+		deleted[entry.catalogEntryKey()] = entry
+	}
+	return deleted, nil
+}
+
+// metadataFileNames - Возвращает имена файлов записей без повторов
+func metadataFileNames(metadata []BackupMetadata) []string {
+	var fileNames []string
+	for _, m := range metadata {
+		if !containsString(fileNames, m.FileName) {
+			fileNames = append(fileNames, m.FileName)
+		}
+	}
+	return fileNames
+}
+
+// putBackupMetadata - Заменяет записи о файлах каталога бэкапов: metadata содержит все наборы каждого файла
+// (RESTORE HEADERONLY), прежние записи об этих файлах удаляются
+func putBackupMetadata(backupDir string, metadata ...BackupMetadata) error {
+	fileNames := metadataFileNames(metadata)
+	cat := getCatalog()
+	if cat == nil {
+		return modifyMetadataJSON(backupDir, func(existing []BackupMetadata) []BackupMetadata {
+			var result []BackupMetadata
+			for _, m := range existing {
+				if !containsString(fileNames, m.FileName) {
+					result = append(result, m)
+				}
+			}
+			return append(result, metadata...)
+		})
+	}
+	if err := ensureCatalogDirectory(cat, backupDir); err != nil {
+		return err
+	}
+
+	entries := make([]CatalogEntry, 0, len(metadata))
+	for _, m := range metadata {
+		entries = append(entries, newCatalogEntry(backupDir, m))
+	}
+	if err := cat.Update(func(tx *bolt.Tx) error {
+		previous, err := deleteCatalogFiles(tx, filepath.Base(backupDir), fileNames)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			// Результат проверки сохраняется, если набор не изменился (в файл, дописанный с NOINIT, добавились новые наборы)
+			if old, ok := previous[entry.catalogEntryKey()]; ok && old.FirstLSN == entry.FirstLSN && old.LastLSN == entry.LastLSN {
+				entry.VerifyStatus, entry.VerifyTime, entry.VerifyError = old.VerifyStatus, old.VerifyTime, old.VerifyError
+			}
+			if err := putCatalogEntry(tx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("ошибка сохранения записей каталога бэкапов: %w", err)
+	}
+	catalogChanged(cat, backupDir)
+	return nil
+}
+
+// deleteBackupMetadata - Удаляет записи обо всех наборах бэкапа в файлах каталога бэкапов
+func deleteBackupMetadata(backupDir string, fileNames ...string) error {
+	cat := getCatalog()
+	if cat == nil {
+		return modifyMetadataJSON(backupDir, func(existing []BackupMetadata) []BackupMetadata {
+			var remaining []BackupMetadata
+			for _, m := range existing {
+				if !containsString(fileNames, m.FileName) {
+					remaining = append(remaining, m)
+				}
+			}
+			return remaining
+		})
+	}
+
+	if err := cat.Update(func(tx *bolt.Tx) error {
+		_, err := deleteCatalogFiles(tx, filepath.Base(backupDir), fileNames)
+		return err
+	}); err != nil {
+		return fmt.Errorf("ошибка удаления записей каталога бэкапов: %w", err)
+	}
+	catalogChanged(cat, backupDir)
+	return nil
+}
+
+// modifyBackupMetadata - Изменяет записи о файлах каталога бэкапов в одной транзакции;
+// update возвращает true, если запись изменена
+func modifyBackupMetadata(backupDir string, update func(*BackupMetadata) bool) error {
+	cat := getCatalog()
+	if cat == nil {
+		return modifyMetadataJSON(backupDir, func(existing []BackupMetadata) []BackupMetadata {
+			for i := range existing {
+				update(&existing[i])
+			}
+			return existing
+		})
+	}
+	if err := ensureCatalogDirectory(cat, backupDir); err != nil {
+		return err
+	}
+
+	if err := cat.Update(func(tx *bolt.Tx) error {
+		entries, err := readCatalogEntries(tx, filepath.Base(backupDir))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !update(&entry.BackupMetadata) {
+				continue
+			}
+			if err := putCatalogEntry(tx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("ошибка изменения записей каталога бэкапов: %w", err)
+	}
+	catalogChanged(cat, backupDir)
+	return nil
+}
+
+// modifyMetadataJSON - Изменяет backup_metadata.json, когда каталог бэкапов не открыт
+func modifyMetadataJSON(backupDir string, modify func([]BackupMetadata) []BackupMetadata) error {
+	catalogExportMutex.Lock()
+	defer catalogExportMutex.Unlock()
+
+	metadata, err := readMetadataJSON(backupDir)
+	if err != nil {
+		return err
+	}
+	return writeMetadataJSON(backupDir, modify(metadata))
+}
+
+// containsString - Проверяет наличие строки в списке
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBackupMetadata - Возвращает метаданные файлов каталога бэкапов на SMB-шаре
+func GetBackupMetadata(dirName, smbSharePath string) ([]BackupMetadata, error) {
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+	return loadBackupMetadata(filepath.Join(smbSharePath, dirName))
+}
+
+// QueryCatalog - Возвращает записи каталога бэкапов по всем каталогам, удовлетворяющие фильтру (новые первыми)
+func QueryCatalog(filter CatalogFilter) ([]CatalogEntry, error) {
+	cat := getCatalog()
+	if cat == nil {
+		return nil, fmt.Errorf("каталог бэкапов не открыт")
+	}
+
+	result := []CatalogEntry{}
+	err := cat.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(catalogBackupsBucket).ForEach(func(k, v []byte) error {
+			var entry CatalogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("ошибка разбора записи каталога %s: %w", string(k), err)
+			}
+			switch {
+			case filter.Directory != "" && !strings.EqualFold(entry.Directory, filter.Directory):
+			case filter.Database != "" && !strings.EqualFold(entry.Database, filter.Database):
+			case filter.Type != "" && entry.Type != filter.Type:
+			case filter.From != nil && entry.End.Before(*filter.From):
+			case filter.To != nil && entry.End.After(*filter.To):
+			default:
+				result = append(result, entry)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения каталога бэкапов: %w", err)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].End.AfterCT(result[j].End)
+	})
+	return result, nil
+}
+
+// ExportCatalog - Выгружает записи каталога бэкапов в backup_metadata.json и возвращает количество записей
+func ExportCatalog(dirName, smbSharePath string) (int, error) {
+	cat := getCatalog()
+	if cat == nil {
+		return 0, fmt.Errorf("каталог бэкапов не открыт")
+	}
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return 0, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+	return exportMetadataJSON(cat, filepath.Join(smbSharePath, dirName))
+}
+
+// ImportCatalog - Заменяет записи каталога бэкапов содержимым backup_metadata.json и возвращает количество записей
+func ImportCatalog(dirName, smbSharePath string) (int, error) {
+	cat := getCatalog()
+	if cat == nil {
+		return 0, fmt.Errorf("каталог бэкапов не открыт")
+	}
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return 0, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+	backupDir := filepath.Join(smbSharePath, dirName)
+	if _, err := os.Stat(filepath.Join(backupDir, metadataFileName)); err != nil {
+		return 0, fmt.Errorf("файл %s в каталоге '%s' недоступен: %w", metadataFileName, dirName, err)
+	}
+	return importMetadataJSON(cat, backupDir)
+}
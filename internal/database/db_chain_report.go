@@ -0,0 +1,340 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/utils"
+)
+
+// ErrRestoreTimeUnavailable - Желаемый момент восстановления не попадает ни в один интервал восстановления
+var ErrRestoreTimeUnavailable = errors.New("момент восстановления недоступен")
+
+// ChainReportFile - Файл бэкапа в отчете о состоянии цепочек
+type ChainReportFile struct {
+	FileName     string     `json:"fileName"`
+	Position     int        `json:"position,omitempty"` // Номер набора в файле (если наборов несколько)
+	Files        []string   `json:"files,omitempty"`    // Все файлы бэкапа, разбитого на полосы
+	Type         string     `json:"type"`
+	Start        CustomTime `json:"start"`
+	End          CustomTime `json:"end"`
+	FirstLSN     LSN        `json:"firstLsn"`
+	LastLSN      LSN        `json:"lastLsn"`
+	IsCopyOnly   bool       `json:"isCopyOnly"`
+	VerifyStatus string     `json:"verifyStatus,omitempty"`
+	Reason       string     `json:"reason,omitempty"` // Для файлов-сирот - почему файл не может быть использован
+}
+
+// ChainGap - Разрыв цепочки журналов: FirstLSN следующего журнала не совпадает с LastLSN предыдущего
+type ChainGap struct {
+	AfterFile   string     `json:"afterFile"`
+	BeforeFile  string     `json:"beforeFile"`
+	ExpectedLSN LSN        `json:"expectedLsn"` // LastLSN предыдущего журнала
+	ActualLSN   LSN        `json:"actualLsn"`   // FirstLSN следующего журнала
+	From        CustomTime `json:"from"`        // Окончание предыдущего журнала
+	To          CustomTime `json:"to"`          // Начало следующего журнала
+}
+
+// RestoreWindow - Непрерывный интервал времени, на любой момент которого можно восстановить базу
+type RestoreWindow struct {
+	From CustomTime `json:"from"`
+	To   CustomTime `json:"to"` // Совпадает с From, если доступен только момент окончания бэкапа
+}
+
+// ChainReportEntry - Полный бэкап с зависящими от него файлами, разрывами и интервалами восстановления
+type ChainReportEntry struct {
+	Full          ChainReportFile   `json:"full"`
+	Differentials []ChainReportFile `json:"differentials"`
+	Logs          []ChainReportFile `json:"logs"`
+	Gaps          []ChainGap        `json:"gaps"`
+	Windows       []RestoreWindow   `json:"windows"`
+}
+
+// ChainReport - Отчет о состоянии цепочек бэкапов каталога
+type ChainReport struct {
+	Directory string             `json:"directory"`
+	Chains    []ChainReportEntry `json:"chains"`   // Новые первыми
+	Orphans   []ChainReportFile  `json:"orphans"`  // Неполные бэкапы и дифференциальные и журнальные бэкапы, которые нельзя применить
+	CopyOnly  []ChainReportFile  `json:"copyOnly"` // Файлы COPY_ONLY
+	Windows   []RestoreWindow    `json:"windows"`  // Итоговые интервалы восстановления по всем цепочкам (без полных копий)
+	Problems  []string           `json:"problems"` // Описание найденных проблем
+}
+
+// newChainReportFile - Преобразует запись метаданных в файл отчета
+func newChainReportFile(b BackupMetadata) ChainReportFile {
+	file := ChainReportFile{
+		FileName:     b.FileName,
+		Type:         b.Type,
+		Start:        b.Start,
+		End:          b.End,
+		FirstLSN:     b.FirstLSN,
+		LastLSN:      b.LastLSN,
+		IsCopyOnly:   b.IsCopyOnly,
+		VerifyStatus: b.VerifyStatus,
+	}
+	if b.FilePosition() > 1 {
+		file.Position = b.FilePosition()
+	}
+	if b.IsStriped() {
+		file.Files = b.MediaFiles()
+	}
+	return file
+}
+
+// splitLogRuns - Разбивает журналы, отсортированные по FirstLSN, на непрерывные последовательности
+// (FirstLSN каждого журнала равен LastLSN предыдущего) и возвращает разрывы между ними
+func splitLogRuns(logs []BackupMetadata) ([][]BackupMetadata, []ChainGap) {
+	var runs [][]BackupMetadata
+	var gaps []ChainGap
+	for i, log := range logs {
+		if i > 0 {
+			prev := logs[i-1]
+			if log.FirstLSN.Compare(prev.LastLSN) == 0 {
+				runs[len(runs)-1] = append(runs[len(runs)-1], log)
+				continue
+			}
+			gaps = append(gaps, ChainGap{
+				AfterFile:   prev.FileName,
+				BeforeFile:  log.FileName,
+				ExpectedLSN: prev.LastLSN,
+				ActualLSN:   log.FirstLSN,
+				From:        prev.End,
+				To:          log.Start,
+			})
+		}
+		runs = append(runs, []BackupMetadata{log})
+	}
+	return runs, gaps
+}
+
+// baseWindow - Определяет интервал восстановления от полного или дифференциального бэкапа base:
+// от его окончания до конца непрерывной последовательности журналов, к которой он стыкуется.
+// Возвращает также журналы, которые можно применить после base.
+func baseWindow(base BackupMetadata, runs [][]BackupMetadata) (RestoreWindow, []BackupMetadata) {
+	window := RestoreWindow{From: base.End, To: base.End}
+	for _, run := range runs {
+		for i, log := range run {
+			// Первый применимый журнал содержит LastLSN базового бэкапа (как в GetRestoreSequence)
+			if log.FirstLSN.Compare(base.LastLSN) <= 0 && base.LastLSN.Compare(log.LastLSN) <= 0 && !log.Start.Before(base.End.Time) {
+				applied := run[i:]
+				window.To = applied[len(applied)-1].End
+				return window, applied
+			}
+		}
+	}
+	return window, nil
+}
+
+// mergeRestoreWindows - Сортирует интервалы и объединяет пересекающиеся
+func mergeRestoreWindows(windows []RestoreWindow) []RestoreWindow {
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].From.BeforeCT(windows[j].From)
+	})
+	merged := []RestoreWindow{}
+	for _, window := range windows {
+		if n := len(merged); n > 0 && !window.From.AfterCT(merged[n-1].To) {
+			if window.To.AfterCT(merged[n-1].To) {
+				merged[n-1].To = window.To
+			}
+			continue
+		}
+		merged = append(merged, window)
+	}
+	return merged
+}
+
+// analyzeBackupChains - Строит отчет о цепочках по метаданным каталога
+func analyzeBackupChains(dirName string, metadata []BackupMetadata) *ChainReport {
+	report := &ChainReport{
+		Directory: dirName,
+		Chains:    []ChainReportEntry{},
+		Orphans:   []ChainReportFile{},
+		CopyOnly:  []ChainReportFile{},
+		Problems:  []string{},
+	}
+
+	for _, b := range metadata {
+		if b.IsCopyOnly {
+			report.CopyOnly = append(report.CopyOnly, newChainReportFile(b))
+		}
+	}
+
+	// Неполные бэкапы (например, без части полос) не входят в цепочки, как и в GetRestoreSequence
+	complete, incomplete := splitIncompleteBackups(metadata)
+	for _, b := range incomplete {
+		file := newChainReportFile(b)
+		file.Reason = b.IncompleteReason
+		report.Orphans = append(report.Orphans, file)
+		report.Problems = append(report.Problems, fmt.Sprintf("Бэкап %s нельзя восстановить: %s", b.FileName, b.IncompleteReason))
+	}
+
+	units, orphans := groupBackupChains(complete)
+	var allWindows []RestoreWindow
+	regularFulls := 0
+	for _, unit := range units {
+		entry := ChainReportEntry{
+			Full:          newChainReportFile(unit.full),
+			Differentials: []ChainReportFile{},
+			Logs:          []ChainReportFile{},
+			Gaps:          []ChainGap{},
+		}
+		if !unit.full.IsCopyOnly {
+			regularFulls++
+		}
+
+		var diffs, logs []BackupMetadata
+		for _, member := range unit.members {
+			switch {
+			case member.IsCopyOnly:
+				// Копии не участвуют в цепочке и перечислены в CopyOnly
+			case member.Type == "Transaction Log":
+				logs = append(logs, member)
+			default:
+				diffs = append(diffs, member)
+			}
+		}
+		sort.Slice(diffs, func(i, j int) bool { return diffs[i].End.BeforeCT(diffs[j].End) })
+		sort.Slice(logs, func(i, j int) bool { return logs[i].FirstLSN.Compare(logs[j].FirstLSN) < 0 })
+
+		runs, gaps := splitLogRuns(logs)
+		entry.Gaps = append(entry.Gaps, gaps...)
+
+		// Интервалы от полного и каждого дифференциального бэкапа; журналы, не применимые ни от одного из них, - сироты
+		usable := make(map[string]bool)
+		bases := append([]BackupMetadata{unit.full}, diffs...)
+		var windows []RestoreWindow
+		for _, base := range bases {
+			window, applied := baseWindow(base, runs)
+			windows = append(windows, window)
+			for _, log := range applied {
+				usable[log.SetKey()] = true
+			}
+		}
+		entry.Windows = mergeRestoreWindows(windows)
+		if !unit.full.IsCopyOnly {
+			// GetRestoreSequence не использует полные копии (COPY_ONLY) как начало цепочки
+			allWindows = append(allWindows, entry.Windows...)
+		}
+
+		for _, diff := range diffs {
+			entry.Differentials = append(entry.Differentials, newChainReportFile(diff))
+		}
+		for _, log := range logs {
+			if !usable[log.SetKey()] {
+				orphan := newChainReportFile(log)
+				orphan.Reason = fmt.Sprintf("журнал не стыкуется ни с полным бэкапом %s, ни с его дифференциальными бэкапами", unit.full.FileName)
+				report.Orphans = append(report.Orphans, orphan)
+				continue
+			}
+			entry.Logs = append(entry.Logs, newChainReportFile(log))
+		}
+
+		for _, gap := range gaps {
+			report.Problems = append(report.Problems, fmt.Sprintf("Разрыв цепочки журналов полного бэкапа %s: после %s (LastLSN=%s) следует %s (FirstLSN=%s), журналы за период %s - %s отсутствуют",
+				unit.full.FileName, gap.AfterFile, gap.ExpectedLSN, gap.BeforeFile, gap.ActualLSN,
+				gap.From.Format("2006-01-02 15:04:05"), gap.To.Format("2006-01-02 15:04:05")))
+		}
+		report.Chains = append(report.Chains, entry)
+	}
+
+	for _, orphan := range orphans {
+		if orphan.IsCopyOnly {
+			continue
+		}
+		file := newChainReportFile(orphan)
+		file.Reason = fmt.Sprintf("в каталоге нет полного бэкапа, на котором основан файл (DatabaseBackupLSN=%s)", orphan.DatabaseBackupLSN)
+		report.Orphans = append(report.Orphans, file)
+	}
+
+	if regularFulls == 0 {
+		report.Problems = append(report.Problems, "В каталоге нет ни одного полного бэкапа без COPY_ONLY")
+	}
+	if len(report.Orphans) > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("Файлов, которые нельзя применить при восстановлении: %d", len(report.Orphans)))
+	}
+	for _, b := range metadata {
+		if b.VerifyStatus == VerifyStatusFailed {
+			report.Problems = append(report.Problems, fmt.Sprintf("Файл %s не прошел проверку RESTORE VERIFYONLY: %s", b.FileName, b.VerifyError))
+		}
+		if b.IsDamaged {
+			report.Problems = append(report.Problems, fmt.Sprintf("Бэкап %s помечен как поврежденный (IsDamaged)", b.FileName))
+		}
+	}
+
+	report.Windows = mergeRestoreWindows(allWindows)
+	return report
+}
+
+// GetChainReport - Анализирует backup_metadata.json каталога: цепочки, разрывы LSN, файлы-сироты,
+// файлы COPY_ONLY и интервалы, на которые возможно восстановление
+func GetChainReport(dirName, smbSharePath string) (*ChainReport, error) {
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+
+	metadata, err := loadBackupMetadata(filepath.Join(smbSharePath, dirName))
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, fmt.Errorf("в каталоге '%s' нет метаданных бэкапов", dirName)
+	}
+
+	// Цепочки строятся, как в GetRestoreSequence, только из бэкапов нужной базы
+	filtered := filterBackupsByDatabase(dirName, metadata)
+	report := analyzeBackupChains(dirName, filtered)
+	if skipped := len(metadata) - len(filtered); skipped > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("В каталоге файлов бэкапа других баз: %d (не входят в цепочки базы %s)",
+			skipped, expectedDatabaseName(dirName, metadata)))
+	}
+	return report, nil
+}
+
+// GetRestoreWindows - Возвращает непрерывные интервалы, на любой момент которых можно восстановить базу
+// из каталога: от окончания полного или дифференциального бэкапа до конца непрерывной цепочки журналов
+func GetRestoreWindows(dirName, smbSharePath string) ([]RestoreWindow, error) {
+	report, err := GetChainReport(dirName, smbSharePath)
+	if err != nil {
+		return nil, err
+	}
+	return report.Windows, nil
+}
+
+// CheckRestoreTime - Проверяет, что момент восстановления попадает в один из интервалов восстановления каталога
+func CheckRestoreTime(dirName string, restoreTime time.Time, smbSharePath string) error {
+	windows, err := GetRestoreWindows(dirName, smbSharePath)
+	if err != nil {
+		return err
+	}
+	if len(windows) == 0 {
+		return fmt.Errorf("для каталога '%s' нет ни одного интервала, на который возможно восстановление", dirName)
+	}
+
+	const layout = "2006-01-02 15:04:05"
+	var before, after *RestoreWindow
+	for i, window := range windows {
+		if !window.From.After(restoreTime) && !window.To.Before(restoreTime) {
+			return nil
+		}
+		if window.To.Before(restoreTime) {
+			before = &windows[i]
+		} else if after == nil {
+			after = &windows[i]
+		}
+	}
+
+	message := fmt.Sprintf("%s (каталог '%s')", restoreTime.Format(layout), dirName)
+	switch {
+	case before == nil:
+		message += fmt.Sprintf(": самый ранний доступный момент - %s", after.From.Format(layout))
+	case after == nil:
+		message += fmt.Sprintf(": самый поздний доступный момент - %s", before.To.Format(layout))
+	default:
+		message += fmt.Sprintf(": он попадает в разрыв между интервалами, ближайшие доступные моменты - %s и %s",
+			before.To.Format(layout), after.From.Format(layout))
+	}
+	return fmt.Errorf("%w: %s", ErrRestoreTimeUnavailable, message)
+}
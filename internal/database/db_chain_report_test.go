@@ -0,0 +1,192 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// testBackup - Запись метаданных для тестов: время задается как "15:04" условного дня, LSN - десятичными строками
+func testBackup(t *testing.T, fileName, backupType, start, end, firstLSN, lastLSN, databaseBackupLSN, checkpointLSN string) BackupMetadata {
+	t.Helper()
+	parseTime := func(value string) CustomTime {
+		parsed, err := time.Parse("2006-01-02 15:04", "2024-03-01 "+value)
+		if err != nil {
+			t.Fatalf("неверное время %q: %v", value, err)
+		}
+		return CustomTime{parsed}
+	}
+	parseLSN := func(value string) LSN {
+		parsed, err := ParseLSN(value)
+		if err != nil {
+			t.Fatalf("неверный LSN %q: %v", value, err)
+		}
+		return parsed
+	}
+	return BackupMetadata{
+		FileName:          fileName,
+		Type:              backupType,
+		Start:             parseTime(start),
+		End:               parseTime(end),
+		FirstLSN:          parseLSN(firstLSN),
+		LastLSN:           parseLSN(lastLSN),
+		DatabaseBackupLSN: parseLSN(databaseBackupLSN),
+		CheckpointLSN:     parseLSN(checkpointLSN),
+	}
+}
+
+// Интервалы восстановления (analyzeBackupChains) и выбор цепочки (buildRestoreSequence) должны совпадать:
+// на любой момент внутри интервала цепочка строится и достигает его, вне интервалов - не достигает.
+func TestRestoreWindowsMatchRestoreSequence(t *testing.T) {
+	const (
+		full   = "Database"
+		diff   = "Database Differential"
+		logBak = "Transaction Log"
+	)
+	backups := []BackupMetadata{
+		// Первая цепочка: журналы непрерывны до 02:01
+		testBackup(t, "F1.bak", full, "00:00", "00:10", "1000", "1100", "0", "1000"),
+		testBackup(t, "L1.trn", logBak, "01:00", "01:01", "900", "2000", "1000", "0"),
+		testBackup(t, "L2.trn", logBak, "02:00", "02:01", "2000", "3000", "1000", "0"),
+		// Дифференциальный бэкап, к которому не стыкуется ни один журнал: от него доступен только момент окончания
+		testBackup(t, "D0.diff", diff, "01:05", "01:10", "1400", "1500", "1000", "1000"),
+		// Дифференциальный бэкап после разрыва журналов
+		testBackup(t, "D1.diff", diff, "03:00", "03:05", "3500", "3600", "1000", "1000"),
+		testBackup(t, "L3.trn", logBak, "04:00", "04:01", "5000", "6000", "1000", "0"),
+		// Вторая цепочка: журнал ссылается на CheckpointLSN полного бэкапа
+		testBackup(t, "F2.bak", full, "06:00", "06:10", "8000", "8100", "0", "8050"),
+		testBackup(t, "L5.trn", logBak, "07:00", "07:01", "8000", "9000", "8050", "0"),
+		// Третья цепочка: полный бэкап без журналов
+		testBackup(t, "F3.bak", full, "08:00", "08:10", "10000", "10100", "0", "10000"),
+	}
+
+	windows := analyzeBackupChains("Test", backups).Windows
+	if len(windows) == 0 {
+		t.Fatal("не построено ни одного интервала восстановления")
+	}
+
+	inWindow := func(moment time.Time) bool {
+		for _, window := range windows {
+			if !window.From.After(moment) && !window.To.Before(moment) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Моменты проверки: границы и середины интервалов, а также моменты между ними
+	var moments []time.Time
+	for i, window := range windows {
+		moments = append(moments, window.From.Time, window.To.Time, window.From.Add(window.To.Sub(window.From.Time)/2))
+		moments = append(moments, window.To.Add(time.Minute))
+		if i == 0 {
+			moments = append(moments, window.From.Add(-time.Minute))
+		}
+	}
+
+	for _, moment := range moments {
+		restoreTime := moment
+		chain, err := buildRestoreSequence(backups, &restoreTime, false)
+		reached := err == nil && !chain[len(chain)-1].End.Before(restoreTime)
+		if expected := inWindow(restoreTime); reached != expected {
+			t.Errorf("момент %s: в интервалах восстановления - %v, цепочка достигает момента - %v (цепочка %v, ошибка %v)",
+				restoreTime.Format("15:04"), expected, reached, chainFileNames(chain), err)
+		}
+	}
+}
+
+// Цепочка начинается с более раннего дифференциального или полного бэкапа, если от последнего
+// дифференциального бэкапа нужный момент недостижим; если момент недостижим ни от одной базы,
+// возвращается цепочка до ближайшего доступного момента, даже когда от последней базы цепочка не строится
+func TestRestoreSequenceFallsBackToEarlierBase(t *testing.T) {
+	backups := []BackupMetadata{
+		testBackup(t, "F1.bak", "Database", "00:00", "00:10", "1000", "1100", "0", "1000"),
+		testBackup(t, "L1.trn", "Transaction Log", "01:00", "01:01", "900", "2000", "1000", "0"),
+		testBackup(t, "L2.trn", "Transaction Log", "02:00", "02:01", "2000", "3000", "1000", "0"),
+		testBackup(t, "D0.diff", "Database Differential", "01:05", "01:10", "1400", "1500", "1000", "1000"),
+	}
+
+	tests := []struct {
+		name   string
+		moment time.Time
+		want   []string
+	}{
+		{"момент достижим от полного бэкапа", time.Date(2024, 3, 1, 1, 30, 0, 0, time.UTC), []string{"F1.bak", "L1.trn", "L2.trn"}},
+		{"момент позже всех цепочек", time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), []string{"F1.bak", "L1.trn", "L2.trn"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreTime := tt.moment
+			chain, err := buildRestoreSequence(backups, &restoreTime, false)
+			if err != nil {
+				t.Fatalf("цепочка не построена: %v", err)
+			}
+			if got := chainFileNames(chain); !equalStrings(got, tt.want) {
+				t.Errorf("цепочка %v, ожидается %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func chainFileNames(chain []BackupMetadata) []string {
+	names := make([]string, 0, len(chain))
+	for _, b := range chain {
+		names = append(names, b.FileName)
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Бэкап без части полос не пропадает из каталога: он попадает в файлы-сироты с причиной
+// и не используется ни в интервалах восстановления, ни в цепочке восстановления
+func TestIncompleteStripeSetIsOrphan(t *testing.T) {
+	older := testBackup(t, "F1.bak", "Database", "00:00", "00:10", "1000", "1100", "0", "1000")
+	stripe := testBackup(t, "F2_1.bak", "Database", "06:00", "06:10", "8000", "8100", "0", "8000")
+	stripe.MediaSetID = "6F1C1C2E-0000-0000-0000-000000000001"
+	stripe.FamilyCount = 3
+	stripe.FamilySequenceNumber = 1
+	secondStripe := stripe
+	secondStripe.FileName = "F2_2.bak"
+	secondStripe.FamilySequenceNumber = 2
+
+	metadata := groupStripeSets([]BackupMetadata{older, stripe, secondStripe})
+	if len(metadata) != 2 {
+		t.Fatalf("после объединения полос %d бэкапов, ожидается 2", len(metadata))
+	}
+	var incomplete BackupMetadata
+	for _, b := range metadata {
+		if b.IsIncomplete() {
+			incomplete = b
+		}
+	}
+	if incomplete.FileName != "F2_1.bak" || !equalStrings(incomplete.MediaFiles(), []string{"F2_1.bak", "F2_2.bak"}) {
+		t.Fatalf("неполный бэкап %q с файлами %v, ожидается F2_1.bak с файлами [F2_1.bak F2_2.bak]", incomplete.FileName, incomplete.MediaFiles())
+	}
+
+	report := analyzeBackupChains("Test", metadata)
+	if len(report.Orphans) != 1 || report.Orphans[0].FileName != "F2_1.bak" || report.Orphans[0].Reason != incomplete.IncompleteReason {
+		t.Errorf("файлы-сироты %+v, ожидается неполный бэкап F2_1.bak с причиной %q", report.Orphans, incomplete.IncompleteReason)
+	}
+	if len(report.Chains) != 1 || report.Chains[0].Full.FileName != "F1.bak" {
+		t.Errorf("цепочек %d, ожидается одна цепочка от F1.bak", len(report.Chains))
+	}
+
+	restoreTime := time.Date(2024, 3, 1, 7, 0, 0, 0, time.UTC)
+	chain, err := buildRestoreSequence(metadata, &restoreTime, false)
+	if err != nil {
+		t.Fatalf("цепочка не построена: %v", err)
+	}
+	if got := chainFileNames(chain); !equalStrings(got, []string{"F1.bak"}) {
+		t.Errorf("цепочка %v, ожидается [F1.bak]", got)
+	}
+}
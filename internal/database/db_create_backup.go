@@ -2,42 +2,189 @@ package database
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/jobs"
 	"github.com/freezzorg/SQLManager/internal/logging"
 	"github.com/freezzorg/SQLManager/internal/utils"
 )
 
-// StartBackup - Запускает асинхронный процесс создания полного бэкапа базы данных
-func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
-	// Переводим базу в однопользовательский режим перед созданием бэкапа
-	if err := SetSingleUserMode(db, dbName); err != nil {
-		return fmt.Errorf("ошибка перевода базы '%s' в однопользовательский режим перед бэкапом: %w", dbName, err)
+// Типы создаваемых бэкапов
+const (
+	BackupTypeFull = "full" // Полный бэкап (.bak)
+	BackupTypeDiff = "diff" // Дифференциальный бэкап (.diff)
+	BackupTypeLog  = "log"  // Бэкап журнала транзакций (.trn)
+)
+
+// Режимы доступа к базе во время бэкапа
+const (
+	BackupModeOnline    = "online"    // Бэкап без ограничения доступа пользователей (по умолчанию)
+	BackupModeExclusive = "exclusive" // Перевод базы в SINGLE_USER на время бэкапа
+)
+
+// BackupOptions - Параметры создания бэкапа, передаваемые из запроса
+type BackupOptions struct {
+	Type        string // full, diff, log (пустое значение - full)
+	Mode        string // online, exclusive (пустое значение - online)
+	CopyOnly    bool   // COPY_ONLY: бэкап не влияет на цепочку бэкапов (базу дифференциальных и журналов)
+	Compression *bool  // COMPRESSION/NO_COMPRESSION (nil - настройка сервера по умолчанию)
+	Checksum    *bool  // CHECKSUM/NO_CHECKSUM (nil - настройка сервера по умолчанию)
+	Description string // DESCRIPTION - описание набора бэкапа (до 255 символов)
+	ScheduleID  string // Идентификатор расписания, запустившего бэкап (пусто - ручной запуск)
+}
+
+// backupFileExtension - Возвращает расширение файла бэкапа для указанного типа
+func backupFileExtension(backupType string) string {
+	switch backupType {
+	case BackupTypeDiff:
+		return ".diff"
+	case BackupTypeLog:
+		return ".trn"
+	default:
+		return ".bak"
 	}
-	
+}
+
+// buildBackupQuery - Формирует команду BACKUP для указанного типа бэкапа и параметров WITH
+func buildBackupQuery(dbName, backupFilePath string, opts BackupOptions) string {
+	withOptions := []string{"INIT"}
+
+	if opts.Type == BackupTypeDiff {
+		withOptions = append(withOptions, "DIFFERENTIAL")
+	} else if opts.CopyOnly {
+		// Для дифференциального бэкапа COPY_ONLY игнорируется сервером, поэтому не указываем его
+		withOptions = append(withOptions, "COPY_ONLY")
+	}
+
+	if opts.Compression != nil {
+		if *opts.Compression {
+			withOptions = append(withOptions, "COMPRESSION")
+		} else {
+			withOptions = append(withOptions, "NO_COMPRESSION")
+		}
+	}
+
+	if opts.Checksum != nil {
+		if *opts.Checksum {
+			withOptions = append(withOptions, "CHECKSUM")
+		} else {
+			withOptions = append(withOptions, "NO_CHECKSUM")
+		}
+	}
+
+	if opts.Description != "" {
+		withOptions = append(withOptions, fmt.Sprintf("DESCRIPTION = N'%s'", strings.ReplaceAll(opts.Description, "'", "''")))
+	}
+
+	backupCommand := "DATABASE"
+	if opts.Type == BackupTypeLog {
+		backupCommand = "LOG"
+	}
+
+	return fmt.Sprintf("BACKUP %s [%s] TO DISK = N'%s' WITH %s", backupCommand, dbName, backupFilePath, strings.Join(withOptions, ", "))
+}
+
+// StartBackup - Запускает асинхронный процесс создания бэкапа базы данных (полного, дифференциального или журнала)
+// и возвращает идентификатор операции. На время бэкапа база блокируется для других операций (jobs.ErrDatabaseBusy).
+func StartBackup(db *sql.DB, dbName string, opts BackupOptions, smbSharePath string) (jobID string, err error) {
+	if opts.Type == "" {
+		opts.Type = BackupTypeFull
+	}
+	switch opts.Type {
+	case BackupTypeFull, BackupTypeDiff, BackupTypeLog:
+	default:
+		return "", fmt.Errorf("неизвестный тип бэкапа '%s' (допустимо: full, diff, log)", opts.Type)
+	}
+	if len([]rune(opts.Description)) > 255 {
+		return "", fmt.Errorf("описание бэкапа не может быть длиннее 255 символов")
+	}
+	if opts.Mode == "" {
+		opts.Mode = BackupModeOnline
+	}
+	if opts.Mode != BackupModeOnline && opts.Mode != BackupModeExclusive {
+		return "", fmt.Errorf("неизвестный режим бэкапа '%s' (допустимо: online, exclusive)", opts.Mode)
+	}
+	exclusive := opts.Mode == BackupModeExclusive
+
+	jobID = jobs.NewID()
+	if err := jobs.LockDatabase(dbName, jobID, jobs.TypeBackup); err != nil {
+		return "", err
+	}
+	// Блокировка освобождается горутиной бэкапа; при ошибке запуска - сразу
+	started := false
+	defer func() {
+		if !started {
+			jobs.UnlockDatabase(dbName, jobID)
+		}
+	}()
+
+	// Бэкап журнала транзакций невозможен для базы с моделью восстановления SIMPLE
+	if opts.Type == BackupTypeLog {
+		recoveryModel, err := getRecoveryModel(db, dbName)
+		if err != nil {
+			return "", err
+		}
+		if recoveryModel == "SIMPLE" {
+			return "", fmt.Errorf("бэкап журнала транзакций невозможен: база '%s' использует модель восстановления SIMPLE", dbName)
+		}
+	}
+
+	// Проверяем, что на SMB-шаре достаточно места для бэкапа
+	if err := CheckBackupFreeSpace(db, dbName, opts.Type, smbSharePath); err != nil {
+		return "", err
+	}
+
 	BackupProgressesMutex.Lock()
 	BackupProgresses[dbName] = &BackupProgress{
-		Status:    "pending",
+		JobID:      jobID,
+		Parameters: backupJobParameters(opts),
+		Status:     "queued",
 		StartTime: time.Now(),
 	}
 	BackupProgressesMutex.Unlock()
+	saveBackupJob(dbName)
 
-	logging.LogWebInfo(fmt.Sprintf("Начато создание бэкапа базы '%s'...", dbName))
+	// Бэкап выполняется из очереди операций с учетом лимитов одновременных операций
+	run := func() {
+		defer jobs.UnlockDatabase(dbName, jobID)
 
-	go func() {
-		// Всегда пытаемся перевести базу в многопользовательский режим после завершения бэкапа
-		defer func() {
-			if err := SetMultiUserMode(db, dbName); err != nil {
-				logging.LogError(fmt.Sprintf("Ошибка перевода базы '%s' в многопользовательский режим после бэкапа: %v", dbName, err))
+		logging.LogWebInfo(fmt.Sprintf("Начато создание бэкапа (%s) базы '%s'...", opts.Type, dbName))
+
+		BackupProgressesMutex.Lock()
+		if progress := BackupProgresses[dbName]; progress != nil {
+			progress.Status = "pending"
+			progress.StartTime = time.Now()
+		}
+		BackupProgressesMutex.Unlock()
+		saveBackupJob(dbName)
+
+		// SQL Server не требует монопольного доступа для BACKUP, поэтому в однопользовательский режим
+		// базу переводим только по явному запросу (режим exclusive)
+		if exclusive {
+			if err := SetSingleUserMode(db, dbName); err != nil {
+				logging.LogError(fmt.Sprintf("Ошибка перевода базы '%s' в однопользовательский режим перед бэкапом: %v", dbName, err))
+				BackupProgressesMutex.Lock()
+				if progress := BackupProgresses[dbName]; progress != nil {
+					progress.Status = "failed"
+					progress.Error = fmt.Sprintf("ошибка перевода базы в однопользовательский режим: %v", err)
+					progress.EndTime = time.Now()
+				}
+				BackupProgressesMutex.Unlock()
+				saveBackupJob(dbName)
+				return
 			}
-		}()
+
+			// В режиме exclusive всегда пытаемся вернуть базу в многопользовательский режим после завершения бэкапа
+			defer func() {
+				if err := SetMultiUserMode(db, dbName); err != nil {
+					logging.LogError(fmt.Sprintf("Ошибка перевода базы '%s' в многопользовательский режим после бэкапа: %v", dbName, err))
+				}
+			}()
+		}
 		
 		// 1. Проверяем и монтируем SMB-шару при необходимости
 		if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
@@ -49,6 +196,7 @@ func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
 				progress.EndTime = time.Now()
 			}
 			BackupProgressesMutex.Unlock()
+			saveBackupJob(dbName)
 			return
 		}
 
@@ -63,11 +211,12 @@ func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
 				progress.EndTime = time.Now()
 			}
 			BackupProgressesMutex.Unlock()
+			saveBackupJob(dbName)
 			return
 		}
 
-		// Формируем имя файла бэкапа: имя_базы_ГГГГММДД_ЧЧММСС.bak
-		backupFileName := fmt.Sprintf("%s_%s.bak", dbName, time.Now().Format("20060102_150405"))
+		// Формируем имя файла бэкапа: имя_базы_ГГГГММДД_ЧЧММСС.bak (.diff/.trn для дифференциального/журнала)
+		backupFileName := fmt.Sprintf("%s_%s%s", dbName, time.Now().Format("20060102_150405"), backupFileExtension(opts.Type))
 		backupFilePath := filepath.Join(backupDir, backupFileName)
 
 		logging.LogDebug(fmt.Sprintf("Путь к файлу бэкапа для базы '%s': %s", dbName, backupFilePath))
@@ -78,11 +227,12 @@ func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
 			progress.BackupFilePath = backupFilePath
 		}
 		BackupProgressesMutex.Unlock()
+		saveBackupJob(dbName)
 
-		// 2. Выполняем команду BACKUP DATABASE
-		backupQuery := fmt.Sprintf("BACKUP DATABASE [%s] TO DISK = N'%s' WITH INIT", dbName, backupFilePath)
+		// 2. Выполняем команду BACKUP DATABASE / BACKUP LOG
+		backupQuery := buildBackupQuery(dbName, backupFilePath, opts)
 
-		logging.LogDebug(fmt.Sprintf("Выполнение BACKUP DATABASE: %s", backupQuery))
+		logging.LogDebug(fmt.Sprintf("Выполнение BACKUP: %s", backupQuery))
 
 		_, err = db.Exec(backupQuery)
 		
@@ -96,6 +246,7 @@ func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
 				progress.EndTime = time.Now()
 			}
 			BackupProgressesMutex.Unlock()
+			saveBackupJob(dbName)
 			return
 		}
 
@@ -103,10 +254,12 @@ func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
 		BackupProgressesMutex.Lock()
 		if progress := BackupProgresses[dbName]; progress != nil {
 			progress.Percentage = 100
+			progress.EstimatedCompletionTime = nil
 			progress.Status = "completed"
 			progress.EndTime = time.Now()
 		}
 		BackupProgressesMutex.Unlock()
+		saveBackupJob(dbName)
 
 		// Обновляем метаданные бэкапа в отдельной горутине
 		go func() {
@@ -119,234 +272,112 @@ func StartBackup(db *sql.DB, dbName string, smbSharePath string) error {
 			}
 		}()
 
-	}()
+	}
 
-	return nil
+	// Отмена до запуска: база не затрагивалась, снимаем блокировку
+	onCancel := func() {
+		BackupProgressesMutex.Lock()
+		if progress := BackupProgresses[dbName]; progress != nil && progress.JobID == jobID {
+			progress.Status = "cancelled"
+			progress.Error = "Отменено пользователем до запуска"
+			progress.EndTime = time.Now()
+		}
+		BackupProgressesMutex.Unlock()
+		saveBackupJob(dbName)
+		jobs.UnlockDatabase(dbName, jobID)
+		logging.LogWebInfo(fmt.Sprintf("Создание бэкапа базы '%s' отменено до запуска", dbName))
+	}
+
+	started = true
+	if position := jobs.Enqueue(jobID, jobs.TypeBackup, dbName, getServerName(db), run, onCancel); position > 0 {
+		logging.LogWebInfo(fmt.Sprintf("Создание бэкапа базы '%s' поставлено в очередь, позиция %d", dbName, position))
+	}
+
+	return jobID, nil
 }
 
 // GetBackupProgress - Возвращает текущий прогресс создания бэкапа для указанной БД
 func GetBackupProgress(db *sql.DB, dbName string) *BackupProgress {
 	BackupProgressesMutex.Lock()
-	defer BackupProgressesMutex.Unlock()
-
-	progress, exists := BackupProgresses[dbName]
-	if !exists {
+	progress := BackupProgresses[dbName]
+	if progress == nil {
+		BackupProgressesMutex.Unlock()
 		return nil
 	}
+	status := progress.Status
+	if status == "queued" {
+		progress.QueuePosition = jobs.QueuePosition(progress.JobID)
+	}
+	BackupProgressesMutex.Unlock()
 
-	// Если бэкап еще не завершен, пытаемся получить процент выполнения из sys.dm_exec_requests
-	if progress.Status == "in_progress" {
-		query := `
-			SELECT r.percent_complete, r.session_id, t.text
-			FROM sys.dm_exec_requests r
-			CROSS APPLY sys.dm_exec_sql_text(r.sql_handle) t
-			WHERE r.command LIKE '%BACKUP%';
-		`
-		rows, err := db.Query(query)
-		if err != nil {
-			return progress
+	if status != "in_progress" {
+		return progress
+	}
+
+	// Запрос к DMV выполняется без блокировки, чтобы не задерживать обновления статуса горутинами бэкапа
+	query := `
+		SELECT r.percent_complete, r.estimated_completion_time, r.session_id, t.text
+		FROM sys.dm_exec_requests r
+		CROSS APPLY sys.dm_exec_sql_text(r.sql_handle) t
+		WHERE r.command LIKE '%BACKUP%';
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return progress
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var percentComplete float64
+		var estimatedCompletionMs int64
+		var sessionID int
+		var commandText sql.NullString
+		if err := rows.Scan(&percentComplete, &estimatedCompletionMs, &sessionID, &commandText); err != nil {
+			continue
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var percentComplete float64
-			var sessionID int
-			var commandText sql.NullString
-			if err := rows.Scan(&percentComplete, &sessionID, &commandText); err != nil {
-				continue
-			}
 
-			// Проверяем, содержит ли текст команды имя целевой базы данных
-			if commandText.Valid && strings.Contains(commandText.String, fmt.Sprintf("DATABASE [%s]", dbName)) {
+		// Проверяем, содержит ли текст команды имя целевой базы данных (BACKUP DATABASE или BACKUP LOG)
+		if commandText.Valid && (strings.Contains(commandText.String, fmt.Sprintf("DATABASE [%s]", dbName)) ||
+			strings.Contains(commandText.String, fmt.Sprintf("LOG [%s]", dbName))) {
+			BackupProgressesMutex.Lock()
+			// За время запроса бэкап мог завершиться
+			if progress.Status == "in_progress" {
 				progress.Percentage = int(percentComplete)
 				progress.SessionID = sessionID
-				break
+				if estimatedCompletionMs > 0 {
+					eta := time.Now().Add(time.Duration(estimatedCompletionMs) * time.Millisecond)
+					progress.EstimatedCompletionTime = &eta
+				}
 			}
+			BackupProgressesMutex.Unlock()
+			break
 		}
 	}
 
 	return progress
 }
 
-// updateBackupMetadata - Обновляет файл метаданных для базы данных
+// updateBackupMetadata - Заносит в каталог бэкапов метаданные всех наборов одного файла бэкапа
 func updateBackupMetadata(db *sql.DB, dbName, backupFilePath, backupDir string) error {
 	logging.LogDebug(fmt.Sprintf("Начало обновления метаданных для бэкапа: %s", backupFilePath))
 	
 	// Получаем метаданные из файла бэкапа
-	newMetadata, err := getBackupHeaderInfo(db, backupFilePath)
+	headers, err := getBackupHeaders(db, backupFilePath)
 	if err != nil {
 		logging.LogError(fmt.Sprintf("Ошибка получения метаданных из файла бэкапа %s: %v", backupFilePath, err))
 		return fmt.Errorf("ошибка получения метаданных из файла бэкапа: %w", err)
 	}
 	
-	logging.LogDebug(fmt.Sprintf("Получены метаданные для файла: %s", newMetadata.FileName))
-
-	// Путь к файлу метаданных
-	metadataPath := filepath.Join(backupDir, "backup_metadata.json")
-	logging.LogDebug(fmt.Sprintf("Путь к файлу метаданных: %s", metadataPath))
-
-	// Читаем существующие метаданные
-	var allMetadata []BackupMetadata
-	if _, err := os.Stat(metadataPath); err == nil {
-		// Файл существует, читаем его
-		logging.LogDebug("Файл метаданных существует, читаем его")
-		data, err := os.ReadFile(metadataPath)
-		if err != nil {
-			logging.LogError(fmt.Sprintf("Ошибка чтения файла метаданных %s: %v", metadataPath, err))
-			return fmt.Errorf("ошибка чтения файла метаданных: %w", err)
-		}
-
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, &allMetadata); err != nil {
-				logging.LogError(fmt.Sprintf("Ошибка разбора JSON файла метаданных %s: %v", metadataPath, err))
-				return fmt.Errorf("ошибка разбора JSON файла метаданных: %w", err)
-			}
-			logging.LogDebug(fmt.Sprintf("Прочитано %d записей из файла метаданных", len(allMetadata)))
-		}
-	} else if !os.IsNotExist(err) {
-		logging.LogError(fmt.Sprintf("Ошибка проверки файла метаданных %s: %v", metadataPath, err))
-		return fmt.Errorf("ошибка проверки файла метаданных: %w", err)
-	} else {
-		logging.LogDebug("Файл метаданных не существует, будет создан новый")
-	}
-
-	// Проверяем, есть ли уже запись с таким именем файла
-	existingIndex := -1
-	for i, metadata := range allMetadata {
-		if metadata.FileName == newMetadata.FileName {
-			existingIndex = i
-			break
-		}
-	}
-
-	if existingIndex != -1 {
-		// Обновляем существующую запись
-		logging.LogDebug(fmt.Sprintf("Обновляем существующую запись для файла: %s", newMetadata.FileName))
-		allMetadata[existingIndex] = *newMetadata
-	} else {
-		// Добавляем новую запись
-		logging.LogDebug(fmt.Sprintf("Добавляем новую запись для файла: %s", newMetadata.FileName))
-		allMetadata = append(allMetadata, *newMetadata)
-	}
-
-	// Сортируем все метаданные по времени начала бэкапа
-	sort.Slice(allMetadata, func(i, j int) bool {
-		return allMetadata[i].Start.Before(allMetadata[j].Start.Time)
-	})
-
-	// Записываем обновленные метаданные обратно в файл
-	data, err := json.MarshalIndent(allMetadata, "", "  ")
-	if err != nil {
-		logging.LogError(fmt.Sprintf("Ошибка сериализации метаданных в JSON: %v", err))
-		return fmt.Errorf("ошибка сериализации метаданных в JSON: %w", err)
-	}
-
-	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
-		logging.LogError(fmt.Sprintf("Ошибка записи файла метаданных %s: %v", metadataPath, err))
-		return fmt.Errorf("ошибка записи файла метаданных: %w", err)
+	if err := putBackupMetadata(backupDir, headers...); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка сохранения метаданных файла %s: %v", backupFilePath, err))
+		return err
 	}
 
-	logging.LogInfo(fmt.Sprintf("Файл метаданных успешно обновлен для базы '%s', файл: %s, всего записей: %d", dbName, backupFilePath, len(allMetadata)))
+	logging.LogInfo(fmt.Sprintf("Метаданные успешно обновлены для базы '%s', файл: %s", dbName, backupFilePath))
 	return nil
 }
 
-// UpdateAllBackupMetadata - Обновляет файл метаданных для всех файлов бэкапов в каталоге
+// UpdateAllBackupMetadata - Обновляет метаданные для всех файлов бэкапов в каталоге
 func UpdateAllBackupMetadata(db *sql.DB, dbName, backupDir string) error {
-	// Получаем список всех файлов бэкапов в каталоге
-	backupFiles, err := getAllBackupFiles(backupDir)
-	if err != nil {
-		return fmt.Errorf("ошибка получения списка файлов бэкапов: %w", err)
-	}
-
-	// Путь к файлу метаданных
-	metadataPath := filepath.Join(backupDir, "backup_metadata.json")
-
-	// Читаем существующие метаданные
-	var allMetadata []BackupMetadata
-	if _, err := os.Stat(metadataPath); err == nil {
-		// Файл существует, читаем его
-		data, err := os.ReadFile(metadataPath)
-		if err != nil {
-			return fmt.Errorf("ошибка чтения файла метаданных: %w", err)
-	}
-
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, &allMetadata); err != nil {
-				return fmt.Errorf("ошибка разбора JSON файла метаданных: %w", err)
-			}
-		}
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("ошибка проверки файла метаданных: %w", err)
-	}
-
-	// Создаем мапу для быстрого поиска существующих метаданных по имени файла
-	metadataMap := make(map[string]*BackupMetadata)
-	for i := range allMetadata {
-		metadataMap[allMetadata[i].FileName] = &allMetadata[i]
-	}
-
-	// Обновляем или добавляем метаданные для каждого файла бэкапа
-	for _, backupFile := range backupFiles {
-		backupFilePath := filepath.Join(backupDir, backupFile)
-
-		// Проверяем, есть ли уже метаданные для этого файла
-		if existingMetadata, exists := metadataMap[backupFile]; exists {
-			// Проверяем, изменилось ли время модификации файла
-			fileInfo, err := os.Stat(backupFilePath)
-			if err != nil {
-				logging.LogError(fmt.Sprintf("Ошибка получения информации о файле %s: %v", backupFilePath, err))
-				continue
-			}
-
-			// Если файл был изменен позже, чем время окончания бэкапа в метаданных, обновляем метаданные
-			if fileInfo.ModTime().After(existingMetadata.End.Time) {
-				newMetadata, err := getBackupHeaderInfo(db, backupFilePath)
-				if err != nil {
-					logging.LogError(fmt.Sprintf("Ошибка получения метаданных из файла %s: %v", backupFilePath, err))
-					continue
-				}
-				// Обновляем существующую запись
-				*existingMetadata = *newMetadata
-			}
-		} else {
-			// Добавляем новую запись
-			newMetadata, err := getBackupHeaderInfo(db, backupFilePath)
-			if err != nil {
-				logging.LogError(fmt.Sprintf("Ошибка получения метаданных из файла %s: %v", backupFilePath, err))
-				continue
-			}
-			allMetadata = append(allMetadata, *newMetadata)
-		}
-	}
-
-	// Удаляем записи для файлов, которые больше не существуют
-	var updatedMetadata []BackupMetadata
-	for _, metadata := range allMetadata {
-		backupFilePath := filepath.Join(backupDir, metadata.FileName)
-		if _, err := os.Stat(backupFilePath); err == nil {
-			// Файл существует, оставляем запись
-			updatedMetadata = append(updatedMetadata, metadata)
-		} else if os.IsNotExist(err) {
-			// Файл не существует, пропускаем запись
-			logging.LogDebug(fmt.Sprintf("Удалена устаревшая запись метаданных для файла: %s", metadata.FileName))
-		}
-	}
-
-	// Сортируем все метаданные по времени начала бэкапа
-	sort.Slice(updatedMetadata, func(i, j int) bool {
-		return updatedMetadata[i].Start.Before(updatedMetadata[j].Start.Time)
-	})
-
-	// Записываем обновленные метаданные обратно в файл
-	data, err := json.MarshalIndent(updatedMetadata, "", " ")
-	if err != nil {
-		return fmt.Errorf("ошибка сериализации метаданных в JSON: %w", err)
-	}
-
-	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
-		return fmt.Errorf("ошибка записи файла метаданных: %w", err)
-	}
-
-	logging.LogInfo(fmt.Sprintf("Файл метаданных обновлен для базы '%s', всего записей: %d", dbName, len(updatedMetadata)))
-	return nil
+	return SyncBackupMetadata(db, dbName, backupDir)
 }
@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -70,45 +70,114 @@ func (ct CustomTime) EqualCT(other CustomTime) bool {
 
 // BackupMetadata представляет структуру метаданных бэкапа
 type BackupMetadata struct {
-	FileName          string     `json:"FileName"`
-	Start             CustomTime `json:"Start"`
-	End               CustomTime `json:"End"`
-	Type              string     `json:"Type"`
-	FirstLSN          string     `json:"FirstLSN"`
-	DatabaseBackupLSN string     `json:"DatabaseBackupLSN"`
-	CheckpointLSN     string     `json:"CheckpointLSN"`
-	LastLSN           string     `json:"LastLSN"`
-	IsCopyOnly        bool       `json:"IsCopyOnly"`
+	FileName             string      `json:"FileName"`
+	Start                CustomTime  `json:"Start"`
+	End                  CustomTime  `json:"End"`
+	Type                 string      `json:"Type"`
+	FirstLSN             LSN         `json:"FirstLSN"`
+	DatabaseBackupLSN    LSN         `json:"DatabaseBackupLSN"`
+	CheckpointLSN        LSN         `json:"CheckpointLSN"`
+	LastLSN              LSN         `json:"LastLSN"`
+	IsCopyOnly           bool        `json:"IsCopyOnly"`
+	Compressed           bool        `json:"Compressed"`
+	HasBackupChecksums   bool        `json:"HasBackupChecksums"`
+	BackupDescription    string      `json:"BackupDescription,omitempty"`
+	VerifyStatus         string      `json:"VerifyStatus,omitempty"`         // Результат RESTORE VERIFYONLY: ok, failed (пусто - не проверялся)
+	VerifyTime           *CustomTime `json:"VerifyTime,omitempty"`           // Время последней проверки
+	VerifyError          string      `json:"VerifyError,omitempty"`          // Ошибка или замечание последней проверки
+	Position             int         `json:"Position,omitempty"`             // Номер набора в файле (FILE = n), 0 - как 1
+	MediaSetID           string      `json:"MediaSetID,omitempty"`           // Идентификатор набора носителей (RESTORE LABELONLY)
+	MediaFamilyID        string      `json:"MediaFamilyID,omitempty"`        // Идентификатор семейства носителей (файла полосы)
+	FamilyCount          int         `json:"FamilyCount,omitempty"`          // Число файлов, на которые разбит бэкап (> 1 - полосы)
+	FamilySequenceNumber int         `json:"FamilySequenceNumber,omitempty"` // Номер файла среди полос
+	StripeFiles          []string    `json:"StripeFiles,omitempty"`          // Все файлы бэкапа, разбитого на полосы (по номеру полосы)
+	IncompleteReason     string      `json:"IncompleteReason,omitempty"`     // Почему бэкап нельзя восстановить (например, отсутствуют полосы); заполняется при чтении каталога
+	DatabaseName         string      `json:"DatabaseName,omitempty"`         // Имя базы данных, из которой сделан бэкап
+	ServerName           string      `json:"ServerName,omitempty"`           // Сервер (экземпляр), на котором сделан бэкап
+	BackupSize           int64       `json:"BackupSize,omitempty"`           // Размер набора бэкапа в байтах
+	CompressedBackupSize int64       `json:"CompressedBackupSize,omitempty"` // Размер набора на носителе (со сжатием) в байтах
+	RecoveryModel        string      `json:"RecoveryModel,omitempty"`        // FULL, BULK-LOGGED, SIMPLE
+	IsDamaged            bool        `json:"IsDamaged,omitempty"`            // Бэкап помечен как поврежденный (CONTINUE_AFTER_ERROR)
+	BackupSetGUID        string      `json:"BackupSetGUID,omitempty"`        // Идентификатор набора бэкапа
+	FamilyGUID           string      `json:"FamilyGUID,omitempty"`           // Идентификатор экземпляра базы (меняется при пересоздании базы)
+	DatabaseVersion      int         `json:"DatabaseVersion,omitempty"`      // Внутренняя версия базы данных
+	SoftwareVersionMajor int         `json:"SoftwareVersionMajor,omitempty"` // Основная версия SQL Server, сделавшего бэкап
+	Collation            string      `json:"Collation,omitempty"`            // Параметры сортировки базы данных
 }
 
 // Структура для хранения логических имен файлов бэкапа (для команды MOVE)
 type BackupLogicalFile struct {
-	LogicalName string
-	Type        string // DATA, LOG
+	LogicalName   string
+	Type          string // DATA, LOG, FILESTREAM, FULLTEXT
+	PhysicalName  string // Исходный физический путь файла на сервере-источнике
+	FileGroupName string // Имя файловой группы (пусто для журнала)
+	FileID        int64  // FileId из RESTORE FILELISTONLY
+	Size          int64  // Размер файла в байтах
 }
 
 // restoreProgress - Структура для отслеживания прогресса восстановления
 type RestoreProgress struct {
-	TotalFiles    int       `json:"totalFiles"`
-	CompletedFiles int       `json:"completedFiles"`
-	CurrentFile   string    `json:"currentFile"`
-	Percentage    int       `json:"percentage"`
-	Status        string    `json:"status"` // "pending", "in_progress", "completed", "failed", "cancelled"
-	StartTime     time.Time `json:"startTime"`
-	EndTime       time.Time `json:"endTime"`
-	Error         string    `json:"error,omitempty"`
-	CancelFunc    context.CancelFunc `json:"-"` // Функция для отмены контекста горутины
+	JobID                   string             `json:"jobId"`                    // Идентификатор операции в хранилище операций
+	BackupBaseName          string             `json:"backupBaseName,omitempty"` // Имя директории бэкапа
+	Parameters              map[string]string  `json:"parameters,omitempty"`     // Параметры запроса на восстановление
+	Chain                   []string           `json:"chain,omitempty"`          // Файлы бэкапов в цепочке восстановления
+	TotalFiles              int                `json:"totalFiles"`
+	CompletedFiles          int                `json:"completedFiles"`
+	CurrentFile             string             `json:"currentFile"`
+	Percentage              int                `json:"percentage"`
+	Status                  string             `json:"status"`                  // "queued", "pending", "in_progress", "completed", "failed", "cancelled"
+	QueuePosition           int                `json:"queuePosition,omitempty"` // Позиция в очереди операций (для статуса "queued")
+	StartTime               time.Time          `json:"startTime"`
+	EndTime                 time.Time          `json:"endTime"`
+	Error                   string             `json:"error,omitempty"`
+	RequestedTime           *time.Time         `json:"requestedTime,omitempty"`           // Желаемый момент восстановления (PITR)
+	ReachedTime             *time.Time         `json:"reachedTime,omitempty"`             // Момент, который фактически будет достигнут цепочкой
+	ExactTime               bool               `json:"exactTime"`                         // true - желаемый момент достигается точно, false - использован ближайший доступный
+	CurrentFilePercent      float64            `json:"currentFilePercent"`                // Процент выполнения текущего RESTORE (sys.dm_exec_requests)
+	EstimatedCompletionTime *time.Time         `json:"estimatedCompletionTime,omitempty"` // Ожидаемое завершение текущего RESTORE (estimated_completion_time)
+	SessionID               int                `json:"sessionID,omitempty"`               // Session ID текущего RESTORE
+	RestoreStarted          bool               `json:"restoreStarted"`                    // Выполнялась хотя бы одна команда RESTORE (целевая база затронута)
+	CancelFunc              context.CancelFunc `json:"-"`                                 // Функция для отмены контекста горутины
+	fileSizes               []int64            // Размеры файлов цепочки для взвешивания процента
+}
+
+// updatePercentage - Пересчитывает общий процент восстановления с учетом процента текущего файла,
+// взвешивая файлы цепочки по размеру (при неизвестных размерах - по количеству файлов)
+func (p *RestoreProgress) updatePercentage() {
+	var totalSize, doneSize float64
+	for i, size := range p.fileSizes {
+		totalSize += float64(size)
+		if i < p.CompletedFiles {
+			doneSize += float64(size)
+		} else if i == p.CompletedFiles {
+			doneSize += float64(size) * p.CurrentFilePercent / 100
+		}
+	}
+
+	switch {
+	case totalSize > 0:
+		p.Percentage = int(doneSize * 100 / totalSize)
+	case p.TotalFiles > 0:
+		p.Percentage = int((float64(p.CompletedFiles)*100 + p.CurrentFilePercent) / float64(p.TotalFiles))
+	}
+	if p.Percentage > 100 {
+		p.Percentage = 100
+	}
 }
 
 // backupProgress - Структура для отслеживания прогресса создания бэкапа
 type BackupProgress struct {
-	Percentage    int       `json:"percentage"`
-	Status        string    `json:"status"` // "pending", "in_progress", "completed", "failed", "cancelled"
-	StartTime     time.Time `json:"startTime"`
-	EndTime       time.Time `json:"endTime"`
-	Error         string    `json:"error,omitempty"`
-	BackupFilePath string   `json:"backupFilePath,omitempty"` // Путь к создаваемому файлу бэкапа
-	SessionID     int       `json:"sessionID,omitempty"`      // Session ID процесса BACKUP
+	JobID                   string            `json:"jobId"`                // Идентификатор операции в хранилище операций
+	Parameters              map[string]string `json:"parameters,omitempty"` // Параметры запроса на бэкап
+	Percentage              int               `json:"percentage"`
+	Status                  string            `json:"status"`                  // "queued", "pending", "in_progress", "completed", "failed", "cancelled"
+	QueuePosition           int               `json:"queuePosition,omitempty"` // Позиция в очереди операций (для статуса "queued")
+	StartTime               time.Time         `json:"startTime"`
+	EndTime                 time.Time         `json:"endTime"`
+	Error                   string            `json:"error,omitempty"`
+	BackupFilePath          string            `json:"backupFilePath,omitempty"`          // Путь к создаваемому файлу бэкапа
+	SessionID               int               `json:"sessionID,omitempty"`               // Session ID процесса BACKUP
+	EstimatedCompletionTime *time.Time        `json:"estimatedCompletionTime,omitempty"` // Ожидаемое завершение BACKUP (estimated_completion_time)
 }
 
 // Глобальная карта для хранения прогресса восстановления по имени новой БД
@@ -156,6 +225,19 @@ func checkDatabaseExists(db *sql.DB, dbName string) (bool, error) {
 	return false, nil
 }
 
+// getRecoveryModel - Возвращает модель восстановления базы данных (SIMPLE, FULL, BULK_LOGGED)
+func getRecoveryModel(db *sql.DB, dbName string) (string, error) {
+	query := fmt.Sprintf("SELECT recovery_model_desc FROM sys.databases WHERE name = N'%s'", dbName)
+	var recoveryModel string
+	if err := db.QueryRow(query).Scan(&recoveryModel); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("база данных '%s' не найдена", dbName)
+		}
+		return "", fmt.Errorf("ошибка получения модели восстановления базы данных '%s': %w", dbName, err)
+	}
+	return strings.ToUpper(recoveryModel), nil
+}
+
 // GetDatabases - Получение списка пользовательских баз данных
 func GetDatabases(db *sql.DB) ([]config.Database, error) {
 	query := `
@@ -239,40 +321,51 @@ func checkAndCreateBackupDir(dbName string, smbSharePath string) (string, error)
     return backupDir, nil
 }
 
-// compareLSN производит лексикографическое сравнение строк (подходит для формата вида 1454000000767000081)
-func compareLSN(a, b string) int {
-	if a == b {	return 0 }
-	if a > b { return 1	}
-	return -1
-}
 
-// GetBackupLogicalFiles - Получение логических имен файлов из бэкапа (для формирования MOVE)
-func GetBackupLogicalFiles(db *sql.DB, backupPath string) ([]BackupLogicalFile, error) {
-	query := fmt.Sprintf("RESTORE FILELISTONLY FROM DISK = N'%s'", backupPath)
+// GetBackupLogicalFiles - Получение логических имен файлов из набора бэкапа (для формирования MOVE).
+// media - источник вида DISK = N'...'[, DISK = N'...'], position - номер набора (FILE = n).
+func GetBackupLogicalFiles(db *sql.DB, media string, position int) ([]BackupLogicalFile, error) {
+	query := fmt.Sprintf("RESTORE FILELISTONLY FROM %s WITH FILE = %d", media, position)
 
     rows, err := db.Query(query)
     if err != nil {
-        return nil, fmt.Errorf("ошибка при запросе RESTORE FILELISTONLY для файла %s: %w", backupPath, err)
+        return nil, fmt.Errorf("ошибка при запросе RESTORE FILELISTONLY FROM %s: %w", media, err)
     }
     defer rows.Close()
 
     columnNames, err := rows.Columns()
     if err != nil {
-        return nil, fmt.Errorf("ошибка получения имен столбцов для файла %s: %w", backupPath, err)
+        return nil, fmt.Errorf("ошибка получения имен столбцов для бэкапа %s: %w", media, err)
     }
 
     // Ищем индексы нужных столбцов
-    var logicalNameIdx, typeIdx int = -1, -1
+    var logicalNameIdx, typeIdx, physicalNameIdx, fileGroupNameIdx, fileIDIdx, sizeIdx int = -1, -1, -1, -1, -1, -1
     for i, name := range columnNames {
         switch name {
         case "LogicalName":
             logicalNameIdx = i
         case "Type":
             typeIdx = i
+        case "PhysicalName":
+            physicalNameIdx = i
+        case "FileGroupName":
+            fileGroupNameIdx = i
+        case "FileId":
+            fileIDIdx = i
+        case "Size":
+            sizeIdx = i
         }
     }
     if logicalNameIdx == -1 || typeIdx == -1 {
-        return nil, fmt.Errorf("не найдены столбцы LogicalName или Type для файла %s", backupPath)
+        return nil, fmt.Errorf("не найдены столбцы LogicalName или Type для бэкапа %s", media)
+    }
+
+    // columnString - Значение столбца строки как строка (пусто, если столбец отсутствует или NULL)
+    columnString := func(columns []sql.RawBytes, idx int) string {
+        if idx >= 0 && idx < len(columns) && len(columns[idx]) > 0 {
+            return string(columns[idx])
+        }
+        return ""
     }
 
     var logicalFiles []BackupLogicalFile
@@ -284,48 +377,54 @@ func GetBackupLogicalFiles(db *sql.DB, backupPath string) ([]BackupLogicalFile,
         }
 
         if err := rows.Scan(scanArgs...); err != nil {
-            return nil, fmt.Errorf("ошибка сканирования строки RESTORE FILELISTONLY для файла %s: %w", backupPath, err)
+            return nil, fmt.Errorf("ошибка сканирования строки RESTORE FILELISTONLY для бэкапа %s: %w", media, err)
         }
 
-        var logicalName, fileType string
-        if logicalNameIdx < len(columns) && len(columns[logicalNameIdx]) > 0 {
-            logicalName = string(columns[logicalNameIdx])
-        }
-        if typeIdx < len(columns) && len(columns[typeIdx]) > 0 {
-            fileType = string(columns[typeIdx])
-        }
+        logicalName := columnString(columns, logicalNameIdx)
+        fileType := columnString(columns, typeIdx)
 
         if logicalName == "" || fileType == "" {
             continue // Пропускаем строки с пустыми значениями
         }
 
+        logicalFile := BackupLogicalFile{
+            LogicalName:   logicalName,
+            PhysicalName:  columnString(columns, physicalNameIdx),
+            FileGroupName: columnString(columns, fileGroupNameIdx),
+        }
+        logicalFile.FileID, _ = strconv.ParseInt(columnString(columns, fileIDIdx), 10, 64)
+        logicalFile.Size, _ = strconv.ParseInt(columnString(columns, sizeIdx), 10, 64)
+
         switch strings.ToUpper(fileType) {
         case "D":
-            logicalFiles = append(logicalFiles, BackupLogicalFile{
-                LogicalName: logicalName,
-                Type:        "DATA",
-            })
+            logicalFile.Type = "DATA"
         case "L":
-            logicalFiles = append(logicalFiles, BackupLogicalFile{
-                LogicalName: logicalName,
-                Type:        "LOG",
-            })
+            logicalFile.Type = "LOG"
+        case "S":
+            logicalFile.Type = "FILESTREAM"
+        case "F":
+            logicalFile.Type = "FULLTEXT"
+        default:
+            logging.LogError(fmt.Sprintf("Неизвестный тип файла '%s' (%s) в бэкапе %s", fileType, logicalName, media))
+            continue
         }
+        logicalFiles = append(logicalFiles, logicalFile)
     }
 
     if err := rows.Err(); err != nil {
-        return nil, fmt.Errorf("ошибка после итерации строк RESTORE FILELISTONLY для файла %s: %w", backupPath, err)
+        return nil, fmt.Errorf("ошибка после итерации строк RESTORE FILELISTONLY для бэкапа %s: %w", media, err)
     }
 
     if len(logicalFiles) == 0 {
-        return nil, fmt.Errorf("RESTORE FILELISTONLY не вернул DATA или LOG файлы для файла %s", backupPath)
+        return nil, fmt.Errorf("RESTORE FILELISTONLY не вернул DATA или LOG файлы для бэкапа %s", media)
     }
 
     return logicalFiles, nil
 }
 
-// getBackupHeaderInfo - Получение метаданных бэкапа из файла с помощью RESTORE HEADERONLY
-func getBackupHeaderInfo(db *sql.DB, backupFilePath string) (*BackupMetadata, error) {
+// getBackupHeaders - Получение метаданных всех наборов бэкапа в файле с помощью RESTORE HEADERONLY
+// (файл, дописанный с NOINIT, содержит несколько наборов) и сведений о носителе из RESTORE LABELONLY
+func getBackupHeaders(db *sql.DB, backupFilePath string) ([]BackupMetadata, error) {
 	logging.LogDebug(fmt.Sprintf("Получение метаданных для файла бэкапа: %s", backupFilePath))
 	
 	query := fmt.Sprintf("RESTORE HEADERONLY FROM DISK = N'%s'", backupFilePath)
@@ -346,6 +445,12 @@ func getBackupHeaderInfo(db *sql.DB, backupFilePath string) (*BackupMetadata, er
 	
 	logging.LogDebug(fmt.Sprintf("Количество столбцов в RESTORE HEADERONLY: %d", len(columns)))
 
+	// Индексы необязательных столбцов (могут отсутствовать в старых версиях SQL Server)
+	columnIndex := make(map[string]int, len(columns))
+	for i, name := range columns {
+		columnIndex[name] = i
+	}
+
 	// Ищем индексы нужных столбцов
 	var backupTypeIdx, backupStartDateIdx, backupFinishDateIdx, firstLSNIdx, 
 		lastLSNIdx, checkpointLSNIdx, databaseBackupLSNIdx, isCopyOnlyIdx int = -1, -1, -1, -1, -1, -1, -1, -1
@@ -378,7 +483,8 @@ func getBackupHeaderInfo(db *sql.DB, backupFilePath string) (*BackupMetadata, er
 		return nil, fmt.Errorf("не найдены необходимые столбцы в результатах RESTORE HEADERONLY")
 	}
 
-	if rows.Next() {
+	var headers []BackupMetadata
+	for rows.Next() {
 		// Создаем срез для хранения всех значений
 		values := make([]interface{}, len(columns))
 		valuePtrs := make([]interface{}, len(columns))
@@ -556,40 +662,156 @@ func getBackupHeaderInfo(db *sql.DB, backupFilePath string) (*BackupMetadata, er
 			}
 		}
 		
+		// Необязательные столбцы: сжатие, контрольные суммы и описание
+		var compressed, hasBackupChecksums bool
+		var backupDescription string
+		if idx, ok := columnIndex["Compressed"]; ok {
+			compressed = headerValueBool(values[idx])
+		}
+		if idx, ok := columnIndex["HasBackupChecksums"]; ok {
+			hasBackupChecksums = headerValueBool(values[idx])
+		}
+		if idx, ok := columnIndex["BackupDescription"]; ok {
+			backupDescription = headerValueString(values[idx])
+		}
+		position := 1
+		if idx, ok := columnIndex["Position"]; ok && values[idx] != nil {
+			position = headerValueInt(values[idx])
+		}
+		// Сведения о базе и сервере (для проверки, что в каталоге бэкапы нужной базы и совместимой версии)
+		optional := func(name string) interface{} {
+			if idx, ok := columnIndex[name]; ok {
+				return values[idx]
+			}
+			return nil
+		}
+
 		logging.LogDebug(fmt.Sprintf("Тип бэкапа: %d, Start: %v, End: %v", backupType, backupStartDate, backupFinishDate))
 
-		// Определяем тип бэкапа
-		var backupTypeStr string
-		switch backupType {
-		case 1:
-			backupTypeStr = "Database"
-		case 5:
-			backupTypeStr = "Database Differential"
-		case 2:
-			backupTypeStr = "Transaction Log"
-		default:
-			backupTypeStr = fmt.Sprintf("Unknown (%d)", backupType)
+		backupTypeStr := backupTypeName(backupType)
+
+		// LSN в RESTORE HEADERONLY - numeric(25,0), сравниваются численно (см. LSN)
+		var lsns [4]LSN
+		for i, value := range []struct{ name, value string }{
+			{"FirstLSN", firstLSN}, {"DatabaseBackupLSN", databaseBackupLSN}, {"CheckpointLSN", checkpointLSN}, {"LastLSN", lastLSN},
+		} {
+			parsed, err := ParseLSN(value.value)
+			if err != nil {
+				return nil, fmt.Errorf("ошибка разбора %s файла бэкапа %s: %w", value.name, backupFilePath, err)
+			}
+			lsns[i] = parsed
 		}
 
-		// Создаем и возвращаем структуру BackupMetadata
-		metadata := &BackupMetadata{
+		// Создаем структуру BackupMetadata для набора
+		metadata := BackupMetadata{
 			FileName:          filepath.Base(backupFilePath),
 			Start:             CustomTime{backupStartDate},
 			End:               CustomTime{backupFinishDate},
 			Type:              backupTypeStr,
-			FirstLSN:          firstLSN,
-			DatabaseBackupLSN: databaseBackupLSN,
-			CheckpointLSN:     checkpointLSN,
-			LastLSN:           lastLSN,
+			FirstLSN:          lsns[0],
+			DatabaseBackupLSN: lsns[1],
+			CheckpointLSN:     lsns[2],
+			LastLSN:           lsns[3],
 			IsCopyOnly:        isCopyOnly,
+			Compressed:        compressed,
+			HasBackupChecksums: hasBackupChecksums,
+			BackupDescription: backupDescription,
+			Position:          position,
+			DatabaseName:      headerValueString(optional("DatabaseName")),
+			ServerName:        headerValueString(optional("ServerName")),
+			BackupSize:        headerValueInt64(optional("BackupSize")),
+			CompressedBackupSize: headerValueInt64(optional("CompressedBackupSize")),
+			RecoveryModel:     headerValueString(optional("RecoveryModel")),
+			IsDamaged:         optional("IsDamaged") != nil && headerValueBool(optional("IsDamaged")),
+			BackupSetGUID:     headerValueGUID(optional("BackupSetGUID")),
+			FamilyGUID:        headerValueGUID(optional("FamilyGUID")),
+			DatabaseVersion:   headerValueInt(optional("DatabaseVersion")),
+			SoftwareVersionMajor: headerValueInt(optional("SoftwareVersionMajor")),
+			Collation:         headerValueString(optional("Collation")),
 		}
-		
-		logging.LogDebug(fmt.Sprintf("Метаданные успешно получены для файла: %s", metadata.FileName))
-		return metadata, nil
-	} else {
+		headers = append(headers, metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения результатов RESTORE HEADERONLY для файла %s: %w", backupFilePath, err)
+	}
+	if len(headers) == 0 {
 		logging.LogError(fmt.Sprintf("Запрос RESTORE HEADERONLY для %s не вернул строк.", backupFilePath))
 		return nil, fmt.Errorf("не найдено метаданных для файла бэкапа %s", backupFilePath)
 	}
+
+	// Сведения о носителе общие для всех наборов файла; без них файл считается отдельным носителем
+	label, err := getMediaLabel(db, backupFilePath)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка чтения RESTORE LABELONLY для файла %s: %v", backupFilePath, err))
+	} else {
+		for i := range headers {
+			headers[i].MediaSetID = label.MediaSetID
+			headers[i].MediaFamilyID = label.MediaFamilyID
+			headers[i].FamilyCount = label.FamilyCount
+			headers[i].FamilySequenceNumber = label.FamilySequenceNumber
+		}
+	}
+
+	logging.LogDebug(fmt.Sprintf("Метаданные успешно получены для файла: %s, наборов: %d", filepath.Base(backupFilePath), len(headers)))
+	return headers, nil
+}
+
+// backupTypeName - Название типа бэкапа по коду BackupType из RESTORE HEADERONLY
+func backupTypeName(backupType int) string {
+	switch backupType {
+	case 1:
+		return "Database"
+	case 5:
+		return "Database Differential"
+	case 2:
+		return "Transaction Log"
+	default:
+		return fmt.Sprintf("Unknown (%d)", backupType)
+	}
+}
+
+// headerValueString - Преобразует значение столбца RESTORE HEADERONLY в строку
+func headerValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case *string:
+		if v != nil {
+			return *v
+		}
+		return ""
+	case []uint8:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// headerValueBool - Преобразует значение столбца RESTORE HEADERONLY в bool
+func headerValueBool(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case *bool:
+		return v != nil && *v
+	case int32:
+		return v != 0
+	case int64:
+		return v != 0
+	case int:
+		return v != 0
+	case []uint8:
+		var val int
+		fmt.Sscanf(string(v), "%d", &val)
+		return val != 0
+	default:
+		logging.LogError(fmt.Sprintf("Неожиданный тип для логического столбца: %T, значение: %v", value, value))
+		return false
+	}
 }
 
 // getAllBackupFiles - Получает список всех файлов бэкапов в каталоге
@@ -620,119 +842,17 @@ func getAllBackupFiles(backupDir string) ([]string, error) {
 	return backupFiles, nil
 }
 
-// SyncBackupMetadata - Синхронизирует файл метаданных с файлами бэкапов в каталоге
+// SyncBackupMetadata - Синхронизирует записи каталога бэкапов с файлами бэкапов в каталоге:
+// добавляет новые и измененные файлы и удаляет записи о файлах, которых больше нет
 func SyncBackupMetadata(db *sql.DB, dbName, backupDir string) error {
 	logging.LogInfo(fmt.Sprintf("Начало синхронизации метаданных для базы '%s' в каталоге %s", dbName, backupDir))
-	
-	// Получаем список всех файлов бэкапов в каталоге
-	backupFiles, err := getAllBackupFiles(backupDir)
-	if err != nil {
-		return fmt.Errorf("ошибка получения списка файлов бэкапов: %w", err)
-	}
-	
-	logging.LogDebug(fmt.Sprintf("Найдено файлов бэкапов: %d", len(backupFiles)))
-	
-	// Путь к файлу метаданных
-	metadataPath := filepath.Join(backupDir, "backup_metadata.json")
-	
-	// Читаем существующие метаданные
-	var existingMetadata []BackupMetadata
-	if _, err := os.Stat(metadataPath); err == nil {
-		// Файл существует, читаем его
-		data, err := os.ReadFile(metadataPath)
-		if err != nil {
-			return fmt.Errorf("ошибка чтения файла метаданных: %w", err)
-		}
-		
-		if len(data) > 0 {
-			if err := json.Unmarshal(data, &existingMetadata); err != nil {
-				return fmt.Errorf("ошибка разбора JSON файла метаданных: %w", err)
-			}
-		}
-		logging.LogDebug(fmt.Sprintf("Прочитано существующих записей метаданных: %d", len(existingMetadata)))
-	} else if !os.IsNotExist(err) {
-		return fmt.Errorf("ошибка проверки файла метаданных: %w", err)
-	} else {
-		logging.LogDebug("Файл метаданных не существует, будет создан новый")
-	}
-	
-	// Создаем мапу для быстрого поиска существующих метаданных по имени файла
-	metadataMap := make(map[string]*BackupMetadata)
-	for i := range existingMetadata {
-		metadataMap[existingMetadata[i].FileName] = &existingMetadata[i]
-	}
-	
-	// Проверяем каждый файл бэкапа
-	var updatedMetadata []BackupMetadata
-	for _, backupFile := range backupFiles {
-		backupFilePath := filepath.Join(backupDir, backupFile)
-		
-		// Проверяем, есть ли уже метаданные для этого файла
-		if existingMetadata, exists := metadataMap[backupFile]; exists {
-			// Проверяем, изменилось ли время модификации файла
-			fileInfo, err := os.Stat(backupFilePath)
-			if err != nil {
-				logging.LogError(fmt.Sprintf("Ошибка получения информации о файле %s: %v", backupFilePath, err))
-				continue
-			}
-			
-			// Если файл был изменен позже, чем время окончания бэкапа в метаданных, обновляем метаданные
-			if fileInfo.ModTime().After(existingMetadata.End.Time) {
-				logging.LogDebug(fmt.Sprintf("Файл %s был изменен, обновляем метаданные", backupFile))
-				newMetadata, err := getBackupHeaderInfo(db, backupFilePath)
-				if err != nil {
-					logging.LogError(fmt.Sprintf("Ошибка получения метаданных из файла %s: %v", backupFilePath, err))
-					continue
-				}
-				updatedMetadata = append(updatedMetadata, *newMetadata)
-			} else {
-				// Файл не изменялся, оставляем существующие метаданные
-				updatedMetadata = append(updatedMetadata, *existingMetadata)
-			}
-		} else {
-			// Новый файл, добавляем метаданные
-			logging.LogDebug(fmt.Sprintf("Найден новый файл бэкапа, добавляем метаданные для: %s", backupFile))
-			newMetadata, err := getBackupHeaderInfo(db, backupFilePath)
-			if err != nil {
-				logging.LogError(fmt.Sprintf("Ошибка получения метаданных из файла %s: %v", backupFilePath, err))
-				continue
-			}
-			updatedMetadata = append(updatedMetadata, *newMetadata)
-		}
-	}
-	
-	// Удаляем записи для файлов, которые больше не существуют
-	var finalMetadata []BackupMetadata
-	for _, metadata := range updatedMetadata {
-		backupFilePath := filepath.Join(backupDir, metadata.FileName)
-		if _, err := os.Stat(backupFilePath); err == nil {
-			// Файл существует, оставляем запись
-			finalMetadata = append(finalMetadata, metadata)
-		} else if os.IsNotExist(err) {
-			// Файл не существует, пропускаем запись
-			logging.LogDebug(fmt.Sprintf("Удалена устаревшая запись метаданных для файла: %s", metadata.FileName))
-		} else {
-			// Ошибка доступа к файлу, оставляем запись на всякий случай
-			logging.LogError(fmt.Sprintf("Ошибка проверки файла %s: %v", backupFilePath, err))
-			finalMetadata = append(finalMetadata, metadata)
-		}
-	}
-	
-	// Сортируем все метаданные по времени начала бэкапа
-	sort.Slice(finalMetadata, func(i, j int) bool {
-		return finalMetadata[i].Start.Before(finalMetadata[j].Start.Time)
-	})
-	
-	// Записываем обновленные метаданные обратно в файл
-	data, err := json.MarshalIndent(finalMetadata, "", "  ")
+
+	result, err := indexBackupDirectory(db, backupDir)
 	if err != nil {
-		return fmt.Errorf("ошибка сериализации метаданных в JSON: %w", err)
-	}
-	
-	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
-		return fmt.Errorf("ошибка записи файла метаданных: %w", err)
+		return err
 	}
-	
-	logging.LogInfo(fmt.Sprintf("Файл метаданных синхронизирован для базы '%s', всего записей: %d", dbName, len(finalMetadata)))
+
+	logging.LogInfo(fmt.Sprintf("Метаданные синхронизированы для базы '%s': файлов %d, обновлено записей %d, удалено %d",
+		dbName, result.Files, result.Indexed, result.Removed))
 	return nil
 }
@@ -0,0 +1,344 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/config"
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+// IndexResult - Результат индексации каталога бэкапов
+type IndexResult struct {
+	Directory   string   `json:"directory"`
+	Files       int      `json:"files"`                 // Файлов бэкапов в каталоге
+	Indexed     int      `json:"indexed"`               // Новых и измененных файлов, прочитанных RESTORE HEADERONLY
+	Removed     int      `json:"removed"`               // Удалено записей о файлах, которых больше нет
+	FromHistory int      `json:"fromHistory,omitempty"` // Из них взято из истории msdb без чтения заголовков
+	Errors      []string `json:"errors,omitempty"`      // Файлы, заголовок которых не удалось прочитать
+	Duration    float64  `json:"durationSeconds"`
+}
+
+// Число одновременно читаемых заголовков бэкапов (задается StartIndexer)
+var indexWorkers = 2
+
+// Блокировки каталогов бэкапов на время индексации: обход по расписанию и ручной rescan не выполняются одновременно
+var indexLocks = make(map[string]*sync.Mutex)
+var indexLocksMutex sync.Mutex
+
+// lockIndexDirectory - Блокирует индексацию каталога бэкапов и возвращает функцию разблокировки
+func lockIndexDirectory(dirName string) func() {
+	key := strings.ToLower(dirName)
+	indexLocksMutex.Lock()
+	lock, ok := indexLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		indexLocks[key] = lock
+	}
+	indexLocksMutex.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// loadCatalogFingerprints - Возвращает записи каталога о файлах каталога бэкапов по имени файла
+// (false, если каталог бэкапов не открыт и размеры и время изменения файлов неизвестны)
+func loadCatalogFingerprints(backupDir string) (map[string]CatalogEntry, bool, error) {
+	cat := getCatalog()
+	if cat == nil {
+		return nil, false, nil
+	}
+	if err := ensureCatalogDirectory(cat, backupDir); err != nil {
+		return nil, true, err
+	}
+
+	fingerprints := make(map[string]CatalogEntry)
+	err := cat.View(func(tx *bolt.Tx) error {
+		entries, err := readCatalogEntries(tx, filepath.Base(backupDir))
+		for _, entry := range entries {
+			fingerprints[entry.FileName] = entry
+		}
+		return err
+	})
+	return fingerprints, true, err
+}
+
+// entryOutdated - Проверяет, что запись занесена версией без сведений о базе и наборе бэкапа
+// (DatabaseName, FamilyGUID, BackupSetGUID и др.): RESTORE HEADERONLY и msdb всегда возвращают имя базы
+func entryOutdated(entry CatalogEntry) bool {
+	return entry.DatabaseName == ""
+}
+
+// fileChanged - Проверяет, изменился ли файл бэкапа с момента занесения в каталог.
+// Без каталога (только backup_metadata.json) сравнивается время изменения с временем окончания бэкапа.
+// Записи прежних версий без сведений о базе считаются измененными, чтобы индексатор их перечитал.
+func fileChanged(entry CatalogEntry, info os.FileInfo, hasFingerprint bool) bool {
+	if entryOutdated(entry) {
+		return true
+	}
+	if !hasFingerprint {
+		return info.ModTime().After(entry.End.Time)
+	}
+	// Время в каталоге хранится с точностью до секунды и без часового пояса
+	const layout = "2006-01-02T15:04:05"
+	return entry.Size != info.Size() || entry.ModTime.Format(layout) != info.ModTime().Format(layout)
+}
+
+// Допуски сверки файла с историей msdb: время изменения файла сравнивается с окончанием последнего набора
+// (часы SQL Server и файлового сервера могут расходиться), размер - с суммой compressed_backup_size наборов
+// (заголовки носителя и наборов занимают в файле место сверх compressed_backup_size)
+const (
+	historyTimeTolerance = 5 * time.Minute
+	historySizeTolerance = 16 << 20
+)
+
+// historyMismatch - Проверяет, что наборы из истории msdb описывают файл на диске, и возвращает причину расхождения
+// (пусто, если совпадают). Файл мог быть перезаписан бэкапом, которого нет в истории (копия с другого сервера),
+// или еще записываться.
+func historyMismatch(sets []BackupMetadata, info os.FileInfo) string {
+	if len(sets) == 0 {
+		return "в истории нет наборов файла"
+	}
+
+	// Время в истории msdb хранится без часового пояса, как и время в каталоге
+	last := sets[len(sets)-1]
+	mod := info.ModTime()
+	modTime := time.Date(mod.Year(), mod.Month(), mod.Day(), mod.Hour(), mod.Minute(), mod.Second(), 0, time.UTC)
+	if diff := modTime.Sub(last.End.Time); diff > historyTimeTolerance || diff < -historyTimeTolerance {
+		return fmt.Sprintf("время изменения файла %s, окончание последнего набора в истории %s",
+			modTime.Format("2006-01-02 15:04:05"), last.End.Format("2006-01-02 15:04:05"))
+	}
+
+	var total int64
+	striped := false
+	for _, set := range sets {
+		size := set.CompressedBackupSize
+		if size == 0 {
+			size = set.BackupSize
+		}
+		total += size
+		striped = striped || set.IsStriped()
+	}
+	// Полоса содержит только часть набора, поэтому для полос проверяется лишь верхняя граница
+	if (!striped && info.Size() < total) || info.Size() > total+historySizeTolerance {
+		return fmt.Sprintf("размер файла %s, наборов в истории %s", formatBytes(info.Size()), formatBytes(total))
+	}
+	return ""
+}
+
+// indexBackupDirectory - Заносит в каталог новые и измененные файлы каталога бэкапов
+// (RESTORE HEADERONLY читается параллельно) и удаляет записи о файлах, которых больше нет
+func indexBackupDirectory(db *sql.DB, backupDir string) (*IndexResult, error) {
+	return indexBackupDirectoryFrom(db, backupDir, nil, false)
+}
+
+// indexBackupDirectoryFrom - Индексирует каталог бэкапов, беря наборы новых и измененных файлов из истории
+// (имя файла в нижнем регистре -> наборы), заголовки читаются только для файлов, которых в истории нет
+// или которые не совпадают с ней по времени изменения и размеру.
+// При force перечитываются все файлы каталога, в том числе не изменившиеся.
+func indexBackupDirectoryFrom(db *sql.DB, backupDir string, history map[string][]BackupMetadata, force bool) (*IndexResult, error) {
+	dirName := filepath.Base(backupDir)
+	defer lockIndexDirectory(dirName)()
+
+	start := time.Now()
+	result := &IndexResult{Directory: dirName}
+
+	backupFiles, err := getAllBackupFiles(backupDir)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка файлов бэкапов: %w", err)
+	}
+	result.Files = len(backupFiles)
+
+	known, hasFingerprints, err := loadCatalogFingerprints(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if !hasFingerprints {
+		metadata, err := readMetadataJSON(backupDir)
+		if err != nil {
+			return nil, err
+		}
+		known = make(map[string]CatalogEntry)
+		for _, m := range metadata {
+			known[m.FileName] = CatalogEntry{BackupMetadata: m}
+		}
+	}
+
+	// Новые и измененные файлы
+	var changedFiles []string
+	var changedMetadata []BackupMetadata
+	var indexedFiles int
+	present := make(map[string]bool)
+	for _, backupFile := range backupFiles {
+		present[backupFile] = true
+		info, err := os.Stat(filepath.Join(backupDir, backupFile))
+		if err != nil {
+			logging.LogError(fmt.Sprintf("Ошибка получения информации о файле %s: %v", filepath.Join(backupDir, backupFile), err))
+			continue
+		}
+		if entry, exists := known[backupFile]; exists && !force && !fileChanged(entry, info, hasFingerprints) {
+			continue
+		}
+		if sets, ok := history[strings.ToLower(backupFile)]; ok {
+			if reason := historyMismatch(sets, info); reason != "" {
+				logging.LogDebug(fmt.Sprintf("Файл %s не совпадает с историей msdb (%s), читается заголовок", filepath.Join(backupDir, backupFile), reason))
+				changedFiles = append(changedFiles, backupFile)
+				continue
+			}
+			for _, set := range sets {
+				set.FileName = backupFile
+				changedMetadata = append(changedMetadata, set)
+			}
+			indexedFiles++
+			result.FromHistory++
+			continue
+		}
+		changedFiles = append(changedFiles, backupFile)
+	}
+
+	// Чтение заголовков: не больше indexWorkers одновременно
+	var resultMutex sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, indexWorkers)
+	for _, backupFile := range changedFiles {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(backupFile string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			backupFilePath := filepath.Join(backupDir, backupFile)
+			logging.LogDebug(fmt.Sprintf("Индексация файла бэкапа: %s", backupFilePath))
+			headers, err := getBackupHeaders(db, backupFilePath)
+
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			if err != nil {
+				logging.LogError(fmt.Sprintf("Ошибка получения метаданных из файла %s: %v", backupFilePath, err))
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", backupFile, err))
+				return
+			}
+			changedMetadata = append(changedMetadata, headers...)
+			indexedFiles++
+		}(backupFile)
+	}
+	wg.Wait()
+
+	// Записи для файлов, которые больше не существуют
+	var staleFiles []string
+	for fileName := range known {
+		if present[fileName] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(backupDir, fileName)); os.IsNotExist(err) {
+			logging.LogDebug(fmt.Sprintf("Удалена устаревшая запись метаданных для файла: %s", fileName))
+			staleFiles = append(staleFiles, fileName)
+		}
+	}
+
+	if len(changedMetadata) > 0 {
+		if err := putBackupMetadata(backupDir, changedMetadata...); err != nil {
+			return nil, err
+		}
+	}
+	if len(staleFiles) > 0 {
+		if err := deleteBackupMetadata(backupDir, staleFiles...); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Indexed = indexedFiles
+	result.Removed = len(staleFiles)
+	result.Duration = time.Since(start).Seconds()
+	return result, nil
+}
+
+// RescanBackupDirectory - Индексирует каталог бэкапов на SMB-шаре по запросу (force - перечитать все файлы)
+func RescanBackupDirectory(db *sql.DB, dirName, smbSharePath string, force bool) (*IndexResult, error) {
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+	backupDir := filepath.Join(smbSharePath, dirName)
+	if info, err := os.Stat(backupDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("каталог бэкапов '%s' не найден", dirName)
+	}
+	return indexBackupDirectoryFrom(db, backupDir, nil, force)
+}
+
+// isBlacklistedDirectory - Проверяет, входит ли каталог бэкапов в черный список (как в списке бэкапов веб-интерфейса)
+func isBlacklistedDirectory(dirName string, blacklist []string) bool {
+	for _, blName := range blacklist {
+		if strings.Contains(dirName, blName) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexShare - Обходит все каталоги бэкапов SMB-шары, кроме входящих в черный список.
+// Если задан раздел msdb_import, наборы новых и измененных файлов берутся из истории msdb.
+func indexShare(db *sql.DB, cfg *config.Config) {
+	smbSharePath := cfg.SMBShare.LocalMountPoint
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		logging.LogError(fmt.Sprintf("Индексатор: не удалось смонтировать SMB-шару %s: %v", smbSharePath, err))
+		return
+	}
+	entries, err := os.ReadDir(smbSharePath)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Индексатор: ошибка чтения каталога %s: %v", smbSharePath, err))
+		return
+	}
+
+	var history map[string]map[string][]BackupMetadata
+	if cfg.MSDBImportEnabled() {
+		// Без истории индексатор читает заголовки всех новых и измененных файлов
+		if history, _, err = loadConfiguredMSDBHistory(db, cfg, ""); err != nil {
+			logging.LogError(fmt.Sprintf("Индексатор: история бэкапов msdb недоступна, читаются заголовки файлов: %v", err))
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || isBlacklistedDirectory(entry.Name(), cfg.App.BackupBlacklist) {
+			continue
+		}
+		result, err := indexBackupDirectoryFrom(db, filepath.Join(smbSharePath, entry.Name()), history[strings.ToLower(entry.Name())], false)
+		if err != nil {
+			logging.LogError(fmt.Sprintf("Индексатор: ошибка индексации каталога '%s': %v", entry.Name(), err))
+			continue
+		}
+		if result.Indexed > 0 || result.Removed > 0 {
+			logging.LogInfo(fmt.Sprintf("Индексатор: каталог '%s' - добавлено/обновлено записей %d, удалено %d",
+				entry.Name(), result.Indexed, result.Removed))
+		}
+	}
+}
+
+// StartIndexer - Запускает фоновую индексацию SMB-шары с заданным периодом.
+// На CIFS не работает inotify, поэтому изменения определяются по размеру и времени изменения файлов.
+func StartIndexer(db *sql.DB, cfg *config.Config, interval time.Duration) {
+	if workers := cfg.CatalogIndexWorkers(); workers > 0 {
+		indexWorkers = workers
+	}
+	if interval <= 0 {
+		logging.LogInfo("Фоновая индексация каталога бэкапов отключена")
+		return
+	}
+
+	go func() {
+		logging.LogInfo(fmt.Sprintf("Запущена фоновая индексация каталога бэкапов: период %s, потоков %d", interval, indexWorkers))
+		indexShare(db, cfg)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			indexShare(db, cfg)
+		}
+	}()
+}
@@ -0,0 +1,562 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/events"
+	"github.com/freezzorg/SQLManager/internal/jobs"
+	"github.com/freezzorg/SQLManager/internal/logging"
+)
+
+// Интервал проверки операций, продолжающихся на сервере после перезапуска службы
+const orphanedJobPollInterval = 30 * time.Second
+
+// Кэш имен серверов SQL для лимитов очереди операций
+var serverNames = make(map[*sql.DB]string)
+var serverNamesMutex sync.Mutex
+
+// getServerName - Возвращает имя сервера SQL (@@SERVERNAME), по которому считается лимит операций на сервер
+func getServerName(db *sql.DB) string {
+	serverNamesMutex.Lock()
+	defer serverNamesMutex.Unlock()
+
+	if name, ok := serverNames[db]; ok {
+		return name
+	}
+	var name sql.NullString
+	if err := db.QueryRow("SELECT @@SERVERNAME").Scan(&name); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка получения имени сервера SQL: %v", err))
+		return "default"
+	}
+	serverNames[db] = name.String
+	return name.String
+}
+
+// restoreJobParameters - Параметры восстановления для записи в хранилище операций
+func restoreJobParameters(backupBaseName string, opts RestoreOptions, paths RestorePaths) map[string]string {
+	params := map[string]string{
+		"backupBaseName": backupBaseName,
+		"dataPath":       paths.DataPath,
+		"logPath":        paths.LogPath,
+	}
+	if opts.RestoreTime != nil {
+		params["restoreTime"] = opts.RestoreTime.Format("2006-01-02 15:04:05")
+	}
+	if opts.StopAtMark != "" {
+		params["stopAtMark"] = opts.StopAtMark
+	}
+	if opts.StopBeforeMark != "" {
+		params["stopBeforeMark"] = opts.StopBeforeMark
+	}
+	for logicalName, targetPath := range paths.FileOverrides {
+		params["fileOverride:"+logicalName] = targetPath
+	}
+	return params
+}
+
+// backupJobParameters - Параметры бэкапа для записи в хранилище операций
+func backupJobParameters(opts BackupOptions) map[string]string {
+	params := map[string]string{
+		"type":     opts.Type,
+		"mode":     opts.Mode,
+		"copyOnly": strconv.FormatBool(opts.CopyOnly),
+	}
+	if opts.Compression != nil {
+		params["compression"] = strconv.FormatBool(*opts.Compression)
+	}
+	if opts.Checksum != nil {
+		params["checksum"] = strconv.FormatBool(*opts.Checksum)
+	}
+	if opts.Description != "" {
+		params["description"] = opts.Description
+	}
+	if opts.ScheduleID != "" {
+		params["schedule"] = opts.ScheduleID
+	}
+	return params
+}
+
+// saveRestoreJob - Сохраняет текущее состояние восстановления базы в хранилище операций
+func saveRestoreJob(dbName string) {
+	RestoreProgressesMutex.Lock()
+	progress := RestoreProgresses[dbName]
+	if progress == nil {
+		RestoreProgressesMutex.Unlock()
+		return
+	}
+	job := &jobs.Job{
+		ID:         progress.JobID,
+		Type:       jobs.TypeRestore,
+		Database:   dbName,
+		Parameters: progress.Parameters,
+		Status:     progress.Status,
+		Percentage: progress.Percentage,
+		StartTime:  progress.StartTime,
+		EndTime:    progress.EndTime,
+		Error:      progress.Error,
+		Chain:      progress.Chain,
+	}
+	if progress.RestoreStarted {
+		job.Parameters = make(map[string]string, len(progress.Parameters)+1)
+		for k, v := range progress.Parameters {
+			job.Parameters[k] = v
+		}
+		job.Parameters["restoreStarted"] = "true"
+	}
+	RestoreProgressesMutex.Unlock()
+
+	if err := jobs.Save(job); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка сохранения операции восстановления базы '%s': %v", dbName, err))
+	}
+}
+
+// saveBackupJob - Сохраняет текущее состояние бэкапа базы в хранилище операций
+func saveBackupJob(dbName string) {
+	BackupProgressesMutex.Lock()
+	progress := BackupProgresses[dbName]
+	if progress == nil {
+		BackupProgressesMutex.Unlock()
+		return
+	}
+	job := &jobs.Job{
+		ID:         progress.JobID,
+		Type:       jobs.TypeBackup,
+		Database:   dbName,
+		Parameters: progress.Parameters,
+		Status:     progress.Status,
+		Percentage: progress.Percentage,
+		StartTime:  progress.StartTime,
+		EndTime:    progress.EndTime,
+		Error:      progress.Error,
+	}
+	if progress.BackupFilePath != "" {
+		job.Parameters = make(map[string]string, len(progress.Parameters)+1)
+		for k, v := range progress.Parameters {
+			job.Parameters[k] = v
+		}
+		job.Parameters["backupFilePath"] = progress.BackupFilePath
+	}
+	BackupProgressesMutex.Unlock()
+
+	if err := jobs.Save(job); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка сохранения операции бэкапа базы '%s': %v", dbName, err))
+	}
+}
+
+// findActiveRequest - Ищет в sys.dm_exec_requests выполняющуюся команду RESTORE/BACKUP для базы (0 - не найдена)
+func findActiveRequest(db *sql.DB, jobType, dbName string) (int, error) {
+	command := "BACKUP"
+	if jobType == jobs.TypeRestore || jobType == jobs.TypeRestoreTest {
+		command = "RESTORE"
+	}
+	query := fmt.Sprintf(`
+		SELECT r.session_id, t.text
+		FROM sys.dm_exec_requests r
+		CROSS APPLY sys.dm_exec_sql_text(r.sql_handle) t
+		WHERE r.command LIKE '%%%s%%';
+	`, command)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка запроса sys.dm_exec_requests: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sessionID int
+		var commandText sql.NullString
+		if err := rows.Scan(&sessionID, &commandText); err != nil {
+			continue
+		}
+		if commandText.Valid && (strings.Contains(commandText.String, fmt.Sprintf("DATABASE [%s]", dbName)) ||
+			strings.Contains(commandText.String, fmt.Sprintf("LOG [%s]", dbName))) {
+			return sessionID, nil
+		}
+	}
+	return 0, rows.Err()
+}
+
+// getDatabaseState - Возвращает state_desc базы из sys.databases (пустая строка, если базы нет)
+func getDatabaseState(db *sql.DB, dbName string) (string, error) {
+	var state string
+	err := db.QueryRow(fmt.Sprintf("SELECT state_desc FROM sys.databases WHERE name = N'%s'", dbName)).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка получения состояния базы данных '%s': %w", dbName, err)
+	}
+	return strings.ToUpper(state), nil
+}
+
+// msdbDeviceNamePattern - Шаблон LIKE для physical_device_name, оканчивающегося на имя файла бэкапа
+func msdbDeviceNamePattern(fileName string) string {
+	escaped := strings.NewReplacer("[", "[[]", "%", "[%]", "_", "[_]", "'", "''").Replace(fileName)
+	return "%" + escaped
+}
+
+// Типы бэкапа msdb.dbo.backupset.type для типов бэкапа SQLManager
+var msdbBackupSetTypes = map[string]string{
+	BackupTypeFull: "D",
+	BackupTypeDiff: "I",
+	BackupTypeLog:  "L",
+}
+
+// backupFinishedSince - Проверяет по msdb, записан ли после указанного момента бэкап заданного типа в файл операции.
+// Бэкапы той же базы, сделанные в это время другими средствами (Veeam, SQL Agent), не учитываются.
+func backupFinishedSince(db *sql.DB, dbName, backupType, backupFilePath string, since time.Time) (bool, error) {
+	if backupFilePath == "" {
+		// Операция прервана до выбора файла бэкапа - команда BACKUP не выполнялась
+		return false, nil
+	}
+	if backupType == "" {
+		backupType = BackupTypeFull
+	}
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM msdb.dbo.backupset bs
+		JOIN msdb.dbo.backupmediafamily mf ON mf.media_set_id = bs.media_set_id
+		WHERE bs.database_name = N'%s' AND bs.type = '%s' AND bs.backup_start_date >= '%s'
+			AND mf.physical_device_name LIKE N'%s'`,
+		dbName, msdbBackupSetTypes[backupType], since.Add(-time.Minute).Format("2006-01-02T15:04:05"),
+		msdbDeviceNamePattern(filepath.Base(backupFilePath)))
+	var count int
+	if err := db.QueryRow(query).Scan(&count); err != nil {
+		return false, fmt.Errorf("ошибка запроса msdb.dbo.backupset для базы '%s': %w", dbName, err)
+	}
+	return count > 0, nil
+}
+
+// restoreFinishedSince - Проверяет по msdb.dbo.restorehistory, что после указанного момента в базу
+// восстановлен последний файл цепочки операции (без цепочки - любой восстановленный бэкап)
+func restoreFinishedSince(db *sql.DB, dbName string, chain []string, since time.Time) (bool, error) {
+	fileFilter := ""
+	if len(chain) > 0 {
+		fileFilter = fmt.Sprintf(" AND mf.physical_device_name LIKE N'%s'", msdbDeviceNamePattern(chain[len(chain)-1]))
+	}
+	query := fmt.Sprintf(`
+		SELECT COUNT(*)
+		FROM msdb.dbo.restorehistory rh
+		JOIN msdb.dbo.backupset bs ON bs.backup_set_id = rh.backup_set_id
+		JOIN msdb.dbo.backupmediafamily mf ON mf.media_set_id = bs.media_set_id
+		WHERE rh.destination_database_name = N'%s' AND rh.restore_date >= '%s'%s`,
+		dbName, since.Add(-time.Minute).Format("2006-01-02T15:04:05"), fileFilter)
+	var count int
+	if err := db.QueryRow(query).Scan(&count); err != nil {
+		return false, fmt.Errorf("ошибка запроса msdb.dbo.restorehistory для базы '%s': %w", dbName, err)
+	}
+	return count > 0, nil
+}
+
+// finalizeInterruptedJob - Определяет итоговый статус операции, прерванной перезапуском службы,
+// и снимает блокировку базы, взятую на время отслеживания продолжающейся на сервере команды
+func finalizeInterruptedJob(db *sql.DB, job *jobs.Job) {
+	defer jobs.UnlockDatabase(job.Database, job.ID)
+
+	switch job.Type {
+	case jobs.TypeRestore:
+		state, err := getDatabaseState(db, job.Database)
+		restored := false
+		if err == nil && state == "ONLINE" {
+			// База в сети и до восстановления, если операция прервана до первой команды RESTORE
+			restored, err = restoreFinishedSince(db, job.Database, job.Chain, job.StartTime)
+		}
+		switch {
+		case err != nil:
+			job.Status = "failed"
+			job.Error = fmt.Sprintf("Операция прервана перезапуском службы, результат неизвестен: %v", err)
+		case state == "ONLINE" && restored:
+			job.Status = "completed"
+			job.Error = ""
+		case state == "ONLINE":
+			job.Status = "failed"
+			job.Error = "Операция прервана перезапуском службы, восстановление последнего файла цепочки не найдено в msdb"
+		case state == "":
+			job.Status = "failed"
+			job.Error = "Операция прервана перезапуском службы, база данных отсутствует на сервере"
+		default:
+			job.Status = "failed"
+			job.Error = fmt.Sprintf("Операция прервана перезапуском службы, состояние базы: %s", state)
+		}
+	case jobs.TypeBackup:
+		finished, err := backupFinishedSince(db, job.Database, job.Parameters["type"], job.Parameters["backupFilePath"], job.StartTime)
+		switch {
+		case err != nil:
+			job.Status = "failed"
+			job.Error = fmt.Sprintf("Операция прервана перезапуском службы, результат неизвестен: %v", err)
+		case finished:
+			job.Status = "completed"
+			job.Error = ""
+		default:
+			job.Status = "failed"
+			job.Error = "Операция прервана перезапуском службы, бэкап не найден в msdb"
+		}
+	case jobs.TypeRestoreTest:
+		// Результат теста неизвестен - временная база удаляется
+		job.Status = "failed"
+		job.Error = "Тестовое восстановление прервано перезапуском службы"
+		if exists, err := checkDatabaseExists(db, job.Database); err == nil && exists {
+			if err := DeleteDatabase(db, job.Database); err != nil {
+				job.Error += fmt.Sprintf(", не удалось удалить временную базу: %v", err)
+			}
+		}
+	case jobs.TypeVerify:
+		// Результаты проверки записываются в метаданные только по ее завершении
+		job.Status = "failed"
+		job.Error = "Проверка прервана перезапуском службы, результаты не сохранены"
+	}
+	job.EndTime = time.Now()
+
+	if err := jobs.Save(job); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка сохранения операции %s: %v", job.ID, err))
+	}
+	loadJobProgress(job)
+	logging.LogInfo(fmt.Sprintf("Операция %s (%s, база '%s') после перезапуска: %s", job.ID, job.Type, job.Database, job.Status))
+}
+
+// watchOrphanedJob - Ожидает завершения команды, продолжающейся на сервере, и фиксирует итог операции
+func watchOrphanedJob(db *sql.DB, job *jobs.Job) {
+	for {
+		time.Sleep(orphanedJobPollInterval)
+		sessionID, err := findActiveRequest(db, job.Type, job.Database)
+		if err == nil && sessionID != 0 {
+			continue
+		}
+		finalizeInterruptedJob(db, job)
+		return
+	}
+}
+
+// loadJobProgress - Восстанавливает запись о прогрессе в памяти по сохраненной операции
+func loadJobProgress(job *jobs.Job) {
+	percentage := job.Percentage
+	if job.Status == "completed" {
+		percentage = 100
+	}
+
+	switch job.Type {
+	case jobs.TypeRestore:
+		RestoreProgressesMutex.Lock()
+		RestoreProgresses[job.Database] = &RestoreProgress{
+			JobID:          job.ID,
+			BackupBaseName: job.Parameters["backupBaseName"],
+			Parameters:     job.Parameters,
+			Chain:          job.Chain,
+			TotalFiles:     len(job.Chain),
+			Percentage:     percentage,
+			Status:         job.Status,
+			StartTime:      job.StartTime,
+			EndTime:        job.EndTime,
+			Error:          job.Error,
+			RestoreStarted: job.Parameters["restoreStarted"] == "true",
+		}
+		RestoreProgressesMutex.Unlock()
+	case jobs.TypeBackup:
+		BackupProgressesMutex.Lock()
+		BackupProgresses[job.Database] = &BackupProgress{
+			JobID:          job.ID,
+			Parameters:     job.Parameters,
+			Percentage:     percentage,
+			Status:         job.Status,
+			StartTime:      job.StartTime,
+			EndTime:        job.EndTime,
+			Error:          job.Error,
+			BackupFilePath: job.Parameters["backupFilePath"],
+		}
+		BackupProgressesMutex.Unlock()
+	}
+}
+
+// ReconcileJobs - При запуске сверяет операции, оставшиеся "in_progress", с состоянием сервера
+// (sys.dm_exec_requests, sys.databases, msdb) и загружает последние операции по каждой базе в память
+func ReconcileJobs(db *sql.DB) error {
+	allJobs, err := jobs.List()
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]bool)
+	for _, job := range allJobs {
+		if job.Status == "queued" {
+			// Очередь хранится только в памяти - операция, не успевшая запуститься, отменяется
+			job.Status = "cancelled"
+			job.Error = "Операция из очереди отменена перезапуском службы"
+			job.EndTime = time.Now()
+			if err := jobs.Save(job); err != nil {
+				logging.LogError(fmt.Sprintf("Ошибка сохранения операции %s: %v", job.ID, err))
+			}
+		} else if job.IsActive() {
+			sessionID, err := findActiveRequest(db, job.Type, job.Database)
+			if err != nil {
+				logging.LogError(fmt.Sprintf("Ошибка проверки активных команд для операции %s: %v", job.ID, err))
+			}
+			if sessionID != 0 {
+				// Команда еще выполняется на сервере - отслеживаем ее завершение
+				job.Status = "in_progress"
+				job.Error = fmt.Sprintf("Служба была перезапущена, команда продолжает выполняться на сервере (session_id %d)", sessionID)
+				if err := jobs.Save(job); err != nil {
+					logging.LogError(fmt.Sprintf("Ошибка сохранения операции %s: %v", job.ID, err))
+				}
+				// Пока команда выполняется, новые операции над базой не принимаются (снимается в finalizeInterruptedJob)
+				if err := jobs.LockDatabase(job.Database, job.ID, job.Type); err != nil {
+					logging.LogError(fmt.Sprintf("Ошибка блокировки базы '%s' для операции %s: %v", job.Database, job.ID, err))
+				}
+				go watchOrphanedJob(db, job)
+			} else {
+				finalizeInterruptedJob(db, job)
+			}
+		}
+
+		// В память загружаем только последнюю операцию каждого типа по каждой базе (список отсортирован от новых к старым)
+		key := job.Type + ":" + job.Database
+		if !loaded[key] {
+			loaded[key] = true
+			loadJobProgress(job)
+		}
+	}
+
+	logging.LogInfo(fmt.Sprintf("Загружено операций из хранилища: %d", len(allJobs)))
+	return nil
+}
+
+// liveJobs - Возвращает операции, находящиеся в памяти (с актуальным процентом выполнения), по идентификатору
+func liveJobs(db *sql.DB) map[string]*jobs.Job {
+	live := make(map[string]*jobs.Job)
+
+	RestoreProgressesMutex.Lock()
+	restoreNames := make([]string, 0, len(RestoreProgresses))
+	for dbName := range RestoreProgresses {
+		restoreNames = append(restoreNames, dbName)
+	}
+	RestoreProgressesMutex.Unlock()
+
+	for _, dbName := range restoreNames {
+		// GetRestoreProgress обновляет процент выполнения из sys.dm_exec_requests
+		progress := GetRestoreProgress(db, dbName)
+		if progress == nil || progress.JobID == "" {
+			continue
+		}
+		RestoreProgressesMutex.Lock()
+		live[progress.JobID] = &jobs.Job{
+			ID:                      progress.JobID,
+			Type:                    jobs.TypeRestore,
+			Database:                dbName,
+			Parameters:              progress.Parameters,
+			Status:                  progress.Status,
+			Percentage:              progress.Percentage,
+			StartTime:               progress.StartTime,
+			EndTime:                 progress.EndTime,
+			Error:                   progress.Error,
+			Chain:                   progress.Chain,
+			QueuePosition:           progress.QueuePosition,
+			EstimatedCompletionTime: progress.EstimatedCompletionTime,
+		}
+		RestoreProgressesMutex.Unlock()
+	}
+
+	BackupProgressesMutex.Lock()
+	backupNames := make([]string, 0, len(BackupProgresses))
+	for dbName := range BackupProgresses {
+		backupNames = append(backupNames, dbName)
+	}
+	BackupProgressesMutex.Unlock()
+
+	for _, dbName := range backupNames {
+		// GetBackupProgress обновляет процент выполнения из sys.dm_exec_requests
+		progress := GetBackupProgress(db, dbName)
+		if progress == nil || progress.JobID == "" {
+			continue
+		}
+		BackupProgressesMutex.Lock()
+		live[progress.JobID] = &jobs.Job{
+			ID:                      progress.JobID,
+			Type:                    jobs.TypeBackup,
+			Database:                dbName,
+			Parameters:              progress.Parameters,
+			Status:                  progress.Status,
+			Percentage:              progress.Percentage,
+			StartTime:               progress.StartTime,
+			EndTime:                 progress.EndTime,
+			Error:                   progress.Error,
+			QueuePosition:           progress.QueuePosition,
+			EstimatedCompletionTime: progress.EstimatedCompletionTime,
+		}
+		BackupProgressesMutex.Unlock()
+	}
+
+	return live
+}
+
+// ListJobs - Возвращает все операции (из хранилища и из памяти), новые первыми
+func ListJobs(db *sql.DB) ([]*jobs.Job, error) {
+	stored, err := jobs.List()
+	if err != nil {
+		return nil, err
+	}
+
+	live := liveJobs(db)
+	result := make([]*jobs.Job, 0, len(stored)+len(live))
+	for _, job := range stored {
+		if liveJob, ok := live[job.ID]; ok {
+			liveJob.UpdateTime = job.UpdateTime
+			result = append(result, liveJob)
+			delete(live, job.ID)
+			continue
+		}
+		result = append(result, job)
+	}
+	// Операции, отсутствующие в хранилище (например, если его не удалось открыть)
+	for _, job := range live {
+		result = append(result, job)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.After(result[j].StartTime)
+	})
+	return result, nil
+}
+
+// GetJob - Возвращает операцию по идентификатору (nil, если не найдена)
+func GetJob(db *sql.DB, id string) (*jobs.Job, error) {
+	if liveJob, ok := liveJobs(db)[id]; ok {
+		return liveJob, nil
+	}
+	return jobs.Get(id)
+}
+
+// StartProgressPublisher - Периодически рассылает подписчикам SSE процент выполнения
+// и позицию в очереди активных операций (только при наличии подписчиков и изменениях)
+func StartProgressPublisher(db *sql.DB, interval time.Duration) {
+	go func() {
+		lastState := make(map[string]string)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !events.HasSubscribers() {
+				continue
+			}
+
+			current := make(map[string]string)
+			for id, job := range liveJobs(db) {
+				if !job.IsActive() {
+					continue
+				}
+				state := fmt.Sprintf("%s:%d:%d", job.Status, job.Percentage, job.QueuePosition)
+				current[id] = state
+				if lastState[id] != state {
+					events.Publish(events.TypeProgress, *job)
+				}
+			}
+			lastState = current
+		}
+	}()
+}
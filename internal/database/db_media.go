@@ -0,0 +1,218 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/logging"
+)
+
+// mediaLabel - Сведения о носителе (файле бэкапа) из RESTORE LABELONLY
+type mediaLabel struct {
+	MediaSetID           string
+	MediaFamilyID        string
+	FamilyCount          int
+	FamilySequenceNumber int
+}
+
+// getMediaLabel - Читает сведения о наборе носителей, к которому относится файл бэкапа
+func getMediaLabel(db *sql.DB, backupFilePath string) (*mediaLabel, error) {
+	rows, err := db.Query(fmt.Sprintf("RESTORE LABELONLY FROM DISK = N'%s'", backupFilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("RESTORE LABELONLY не вернул строк")
+	}
+	values := make([]interface{}, len(columns))
+	valuePtrs := make([]interface{}, len(columns))
+	for i := range values {
+		valuePtrs[i] = &values[i]
+	}
+	if err := rows.Scan(valuePtrs...); err != nil {
+		return nil, err
+	}
+
+	label := &mediaLabel{}
+	for i, name := range columns {
+		switch name {
+		case "MediaSetId":
+			label.MediaSetID = headerValueGUID(values[i])
+		case "MediaFamilyId":
+			label.MediaFamilyID = headerValueGUID(values[i])
+		case "FamilyCount":
+			label.FamilyCount = headerValueInt(values[i])
+		case "FamilySequenceNumber":
+			label.FamilySequenceNumber = headerValueInt(values[i])
+		}
+	}
+	return label, nil
+}
+
+// headerValueInt - Преобразует целочисленное значение столбца RESTORE HEADERONLY/LABELONLY в int
+func headerValueInt(value interface{}) int {
+	return int(headerValueInt64(value))
+}
+
+// headerValueInt64 - Преобразует целочисленное значение столбца (в том числе numeric(20,0)) в int64
+func headerValueInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case uint8:
+		return int64(v)
+	case int:
+		return int64(v)
+	case []uint8:
+		var val int64
+		fmt.Sscanf(string(v), "%d", &val)
+		return val
+	case string:
+		var val int64
+		fmt.Sscanf(v, "%d", &val)
+		return val
+	default:
+		logging.LogError(fmt.Sprintf("Неожиданный тип для целочисленного столбца: %T, значение: %v", value, value))
+		return 0
+	}
+}
+
+// headerValueGUID - Преобразует значение столбца uniqueidentifier в строку GUID
+func headerValueGUID(value interface{}) string {
+	if raw, ok := value.([]uint8); ok && len(raw) == 16 {
+		var guid mssql.UniqueIdentifier
+		if err := guid.Scan(raw); err == nil {
+			return guid.String()
+		}
+	}
+	return strings.ToUpper(headerValueString(value))
+}
+
+// FilePosition - Номер набора бэкапа в файле для WITH FILE = n (в метаданных прежних версий не заполнялся)
+func (b BackupMetadata) FilePosition() int {
+	if b.Position > 0 {
+		return b.Position
+	}
+	return 1
+}
+
+// IsStriped - Проверяет, разбит ли бэкап на несколько файлов (полос)
+func (b BackupMetadata) IsStriped() bool {
+	return b.FamilyCount > 1 && b.MediaSetID != ""
+}
+
+// MediaFiles - Все файлы, из которых читается бэкап (полосы по порядку или единственный файл)
+func (b BackupMetadata) MediaFiles() []string {
+	if len(b.StripeFiles) > 0 {
+		return b.StripeFiles
+	}
+	return []string{b.FileName}
+}
+
+// catalogEntryKey - Ключ записи о наборе бэкапа в файле (для первого набора - имя файла, как в прежних версиях)
+func (b BackupMetadata) catalogEntryKey() string {
+	if b.FilePosition() == 1 {
+		return b.FileName
+	}
+	return fmt.Sprintf("%s#%d", b.FileName, b.FilePosition())
+}
+
+// SetKey - Идентификатор логического бэкапа: набор в файле либо набор носителей с полосами
+func (b BackupMetadata) SetKey() string {
+	if b.IsStriped() {
+		return fmt.Sprintf("media:%s#%d", b.MediaSetID, b.FilePosition())
+	}
+	return b.catalogEntryKey()
+}
+
+// IsIncomplete - Проверяет, что бэкап нельзя восстановить (например, отсутствует часть полос)
+func (b BackupMetadata) IsIncomplete() bool {
+	return b.IncompleteReason != ""
+}
+
+// splitIncompleteBackups - Отделяет бэкапы, которые можно восстановить, от неполных
+func splitIncompleteBackups(metadata []BackupMetadata) (complete, incomplete []BackupMetadata) {
+	for _, b := range metadata {
+		if b.IsIncomplete() {
+			incomplete = append(incomplete, b)
+		} else {
+			complete = append(complete, b)
+		}
+	}
+	return complete, incomplete
+}
+
+// mediaClause - Формирует источник команды RESTORE: DISK = N'...' для каждого файла бэкапа
+func mediaClause(backupDir string, b BackupMetadata) string {
+	disks := make([]string, 0, len(b.MediaFiles()))
+	for _, fileName := range b.MediaFiles() {
+		disks = append(disks, fmt.Sprintf("DISK = N'%s'", filepath.Join(backupDir, fileName)))
+	}
+	return strings.Join(disks, ", ")
+}
+
+// groupStripeSets - Объединяет записи о полосах одного бэкапа в один логический бэкап.
+// Имя файла логического бэкапа - первая полоса, в StripeFiles перечисляются все полосы.
+// Бэкапы, часть полос которых отсутствует, не могут быть восстановлены: они возвращаются с найденными
+// полосами и причиной в IncompleteReason, чтобы отчет о цепочках, метаданные и политика хранения их видели.
+func groupStripeSets(metadata []BackupMetadata) []BackupMetadata {
+	var result []BackupMetadata
+	stripes := make(map[string][]BackupMetadata)
+	var order []string
+	for _, b := range metadata {
+		if !b.IsStriped() {
+			result = append(result, b)
+			continue
+		}
+		key := b.SetKey()
+		if _, ok := stripes[key]; !ok {
+			order = append(order, key)
+		}
+		stripes[key] = append(stripes[key], b)
+	}
+
+	for _, key := range order {
+		members := stripes[key]
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].FamilySequenceNumber < members[j].FamilySequenceNumber
+		})
+		files := make([]string, 0, len(members))
+		seen := make(map[int]bool)
+		for _, member := range members {
+			if seen[member.FamilySequenceNumber] {
+				continue
+			}
+			seen[member.FamilySequenceNumber] = true
+			files = append(files, member.FileName)
+		}
+		logical := members[0]
+		logical.StripeFiles = files
+		if len(files) < logical.FamilyCount {
+			logical.IncompleteReason = fmt.Sprintf("отсутствуют полосы бэкапа: найдено %d из %d (%s)",
+				len(files), logical.FamilyCount, strings.Join(files, ", "))
+		}
+		result = append(result, logical)
+	}
+
+	sortBackupMetadata(result)
+	return result
+}
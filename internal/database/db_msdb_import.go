@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/config"
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+)
+
+// История бэкапов на диск (device_type = 2) из msdb сервера-источника: одна строка на набор бэкапа и файл носителя.
+// Столбцы соответствуют RESTORE HEADERONLY и LABELONLY, чтобы записи каталога не отличались от прочитанных из файлов.
+const msdbHistoryQuery = `
+SELECT bs.backup_set_id, bs.position, bs.type, bs.backup_start_date, bs.backup_finish_date,
+	CAST(bs.first_lsn AS varchar(25)), CAST(bs.last_lsn AS varchar(25)),
+	CAST(bs.checkpoint_lsn AS varchar(25)), CAST(bs.database_backup_lsn AS varchar(25)),
+	bs.is_copy_only, bs.has_backup_checksums, bs.description,
+	bs.database_name, bs.server_name, bs.backup_size, bs.compressed_backup_size, bs.recovery_model, bs.is_damaged,
+	bs.backup_set_uuid, bs.family_guid, bs.database_version, bs.software_major_version, bs.collation_name,
+	ms.media_uuid, ms.media_family_count, ms.is_compressed,
+	mf.media_family_id, mf.family_sequence_number, mf.physical_device_name
+FROM msdb.dbo.backupset bs
+JOIN msdb.dbo.backupmediaset ms ON ms.media_set_id = bs.media_set_id
+JOIN msdb.dbo.backupmediafamily mf ON mf.media_set_id = bs.media_set_id
+WHERE mf.device_type = 2 AND bs.is_snapshot = 0 AND mf.physical_device_name LIKE @p1
+ORDER BY bs.backup_set_id`
+
+// Коды типов бэкапа msdb (backupset.type) в кодах BackupType RESTORE HEADERONLY
+var msdbBackupTypes = map[string]int{
+	"D": 1, // Database
+	"L": 2, // Transaction Log
+	"F": 4, // File or filegroup
+	"I": 5, // Database Differential
+	"G": 6, // Differential file
+	"P": 7, // Partial
+	"Q": 8, // Differential partial
+}
+
+// rewriteBackupPath - Переводит путь к файлу бэкапа на сервере-источнике в локальный путь по первому подходящему правилу
+func rewriteBackupPath(physicalPath string, rewrites []config.PathRewrite) (string, bool) {
+	normalized := strings.ReplaceAll(physicalPath, "\\", "/")
+	for _, rule := range rewrites {
+		from := strings.TrimRight(strings.ReplaceAll(rule.From, "\\", "/"), "/")
+		if from == "" || len(normalized) <= len(from) || !strings.EqualFold(normalized[:len(from)], from) || normalized[len(from)] != '/' {
+			continue
+		}
+		return filepath.Join(rule.To, filepath.FromSlash(normalized[len(from)+1:])), true
+	}
+	return "", false
+}
+
+// msdbLikePattern - Шаблон LIKE для отбора файлов каталога бэкапов (лишние строки отсекаются после замены путей).
+// Правила заменяют только префикс пути, поэтому имя каталога бэкапов есть и в physical_device_name.
+func msdbLikePattern(dirName string) string {
+	if dirName == "" {
+		return "%"
+	}
+	escaped := strings.NewReplacer("[", "[[]", "%", "[%]", "_", "[_]").Replace(dirName)
+	return "%" + escaped + "%"
+}
+
+// loadMSDBHistory - Читает историю бэкапов из msdb и раскладывает наборы по локальным каталогам бэкапов
+// (имя каталога в нижнем регистре -> имя файла в нижнем регистре -> наборы). Файлы, для которых не подошло
+// ни одно правило замены путей, пропускаются. Набор с номером 1 означает, что файл записан заново (INIT),
+// поэтому более ранние наборы того же файла отбрасываются.
+func loadMSDBHistory(source *sql.DB, smbSharePath, dirName string, rewrites []config.PathRewrite) (map[string]map[string][]BackupMetadata, int, error) {
+	rows, err := source.Query(msdbHistoryQuery, msdbLikePattern(dirName))
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения истории бэкапов из msdb: %w", err)
+	}
+	defer rows.Close()
+
+	history := make(map[string]map[string][]BackupMetadata)
+	unmapped := 0
+	for rows.Next() {
+		var backupSetID int64
+		var position, databaseVersion, softwareMajor, familyCount, familySequence sql.NullInt64
+		var backupType, firstLSN, lastLSN, checkpointLSN, databaseBackupLSN sql.NullString
+		var description, databaseName, serverName, recoveryModel, collation, physicalName sql.NullString
+		var start, finish sql.NullTime
+		var isCopyOnly, hasChecksums, isDamaged, isCompressed sql.NullBool
+		var backupSize, compressedSize, backupSetGUID, familyGUID, mediaSetID, mediaFamilyID interface{}
+		if err := rows.Scan(&backupSetID, &position, &backupType, &start, &finish,
+			&firstLSN, &lastLSN, &checkpointLSN, &databaseBackupLSN,
+			&isCopyOnly, &hasChecksums, &description,
+			&databaseName, &serverName, &backupSize, &compressedSize, &recoveryModel, &isDamaged,
+			&backupSetGUID, &familyGUID, &databaseVersion, &softwareMajor, &collation,
+			&mediaSetID, &familyCount, &isCompressed,
+			&mediaFamilyID, &familySequence, &physicalName); err != nil {
+			return nil, 0, fmt.Errorf("ошибка разбора истории бэкапов msdb: %w", err)
+		}
+
+		localPath, ok := rewriteBackupPath(physicalName.String, rewrites)
+		if !ok {
+			logging.LogDebug(fmt.Sprintf("Импорт msdb: нет правила замены пути для %s", physicalName.String))
+			unmapped++
+			continue
+		}
+		backupDir := filepath.Dir(localPath)
+		if !strings.EqualFold(filepath.Dir(backupDir), filepath.Clean(smbSharePath)) {
+			continue
+		}
+		if dirName != "" && !strings.EqualFold(filepath.Base(backupDir), dirName) {
+			continue
+		}
+
+		var lsns [4]LSN
+		for i, value := range []sql.NullString{firstLSN, databaseBackupLSN, checkpointLSN, lastLSN} {
+			if lsns[i], err = ParseLSN(value.String); err != nil {
+				return nil, 0, fmt.Errorf("ошибка разбора LSN набора %d из msdb: %w", backupSetID, err)
+			}
+		}
+
+		metadata := BackupMetadata{
+			FileName:             filepath.Base(localPath),
+			Start:                CustomTime{start.Time},
+			End:                  CustomTime{finish.Time},
+			Type:                 backupTypeName(msdbBackupTypes[backupType.String]),
+			FirstLSN:             lsns[0],
+			DatabaseBackupLSN:    lsns[1],
+			CheckpointLSN:        lsns[2],
+			LastLSN:              lsns[3],
+			IsCopyOnly:           isCopyOnly.Bool,
+			Compressed:           isCompressed.Bool,
+			HasBackupChecksums:   hasChecksums.Bool,
+			BackupDescription:    description.String,
+			Position:             int(position.Int64),
+			MediaSetID:           headerValueGUID(mediaSetID),
+			MediaFamilyID:        headerValueGUID(mediaFamilyID),
+			FamilyCount:          int(familyCount.Int64),
+			FamilySequenceNumber: int(familySequence.Int64),
+			DatabaseName:         databaseName.String,
+			ServerName:           serverName.String,
+			BackupSize:           headerValueInt64(backupSize),
+			CompressedBackupSize: headerValueInt64(compressedSize),
+			RecoveryModel:        recoveryModel.String,
+			IsDamaged:            isDamaged.Bool,
+			BackupSetGUID:        headerValueGUID(backupSetGUID),
+			FamilyGUID:           headerValueGUID(familyGUID),
+			DatabaseVersion:      int(databaseVersion.Int64),
+			SoftwareVersionMajor: int(softwareMajor.Int64),
+			Collation:            collation.String,
+		}
+
+		dirKey := strings.ToLower(filepath.Base(backupDir))
+		fileKey := strings.ToLower(metadata.FileName)
+		if history[dirKey] == nil {
+			history[dirKey] = make(map[string][]BackupMetadata)
+		}
+		if metadata.FilePosition() == 1 {
+			history[dirKey][fileKey] = nil
+		}
+		history[dirKey][fileKey] = append(history[dirKey][fileKey], metadata)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения истории бэкапов из msdb: %w", err)
+	}
+	return history, unmapped, nil
+}
+
+// openMSDBSource - Открывает соединение с сервером-источником истории бэкапов (nil, если источник - основной сервер)
+func openMSDBSource(cfg *config.Config) (*sql.DB, error) {
+	source := cfg.MSDBImport
+	if source.Server == "" {
+		return nil, nil
+	}
+	port, user, password := source.Port, source.User, source.Password
+	if port == 0 {
+		port = cfg.MSSQL.Port
+	}
+	if user == "" {
+		user, password = cfg.MSSQL.User, cfg.MSSQL.Password
+	}
+	db, err := sql.Open("sqlserver", fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", source.Server, user, password, port))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("не удалось подключиться к серверу-источнику %s: %w", source.Server, err)
+	}
+	return db, nil
+}
+
+// loadConfiguredMSDBHistory - Читает историю бэкапов из msdb сервера-источника msdb_import (без него - основного сервера)
+// и возвращает ее вместе с числом записей, для которых не нашлось правила замены пути
+func loadConfiguredMSDBHistory(db *sql.DB, cfg *config.Config, dirName string) (map[string]map[string][]BackupMetadata, int, error) {
+	source, err := openMSDBSource(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if source != nil {
+		defer source.Close()
+	} else {
+		source = db
+	}
+
+	start := time.Now()
+	history, unmapped, err := loadMSDBHistory(source, cfg.SMBShare.LocalMountPoint, dirName, cfg.MSDBPathRewrites())
+	if err != nil {
+		return nil, 0, err
+	}
+	logging.LogDebug(fmt.Sprintf("Импорт msdb: история прочитана за %.1f с, каталогов бэкапов в истории: %d", time.Since(start).Seconds(), len(history)))
+	return history, unmapped, nil
+}
+
+// ImportMSDBHistory - Заполняет каталог бэкапов из истории msdb сервера-источника вместо чтения заголовков по SMB.
+// Пути physical_device_name переводятся в пути точки монтирования по правилам msdb_import.path_rewrites;
+// RESTORE HEADERONLY читается только для новых и измененных файлов, которых нет в истории msdb
+// или которые не совпадают с ней по времени изменения и размеру.
+// Пустое dirName - все каталоги бэкапов шары, кроме входящих в черный список.
+func ImportMSDBHistory(db *sql.DB, cfg *config.Config, dirName string) ([]*IndexResult, error) {
+	smbSharePath := cfg.SMBShare.LocalMountPoint
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+
+	var dirNames []string
+	if dirName != "" {
+		if info, err := os.Stat(filepath.Join(smbSharePath, dirName)); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("каталог бэкапов '%s' не найден", dirName)
+		}
+		dirNames = []string{dirName}
+	} else {
+		entries, err := os.ReadDir(smbSharePath)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения каталога %s: %w", smbSharePath, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !isBlacklistedDirectory(entry.Name(), cfg.App.BackupBlacklist) {
+				dirNames = append(dirNames, entry.Name())
+			}
+		}
+	}
+
+	history, unmapped, err := loadConfiguredMSDBHistory(db, cfg, dirName)
+	if err != nil {
+		return nil, err
+	}
+	if unmapped > 0 {
+		logging.LogInfo(fmt.Sprintf("Импорт msdb: для %d записей истории не найдено правило замены пути (msdb_import.path_rewrites)", unmapped))
+	}
+
+	var results []*IndexResult
+	for _, name := range dirNames {
+		result, err := indexBackupDirectoryFrom(db, filepath.Join(smbSharePath, name), history[strings.ToLower(name)], false)
+		if err != nil {
+			if dirName != "" {
+				return nil, err
+			}
+			logging.LogError(fmt.Sprintf("Импорт msdb: ошибка индексации каталога '%s': %v", name, err))
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
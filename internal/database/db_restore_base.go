@@ -3,21 +3,34 @@ package database
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/jobs"
 	"github.com/freezzorg/SQLManager/internal/logging"
 	"github.com/freezzorg/SQLManager/internal/utils"
 )
 
-// GetRestoreSequence - Определяет последовательность бэкапов для восстановления на указанный момент времени
-func GetRestoreSequence(db *sql.DB, baseName string, restoreTime *time.Time, smbSharePath string) ([]BackupMetadata, error) {
+// RestoreOptions - Параметры восстановления на момент времени, передаваемые из запроса
+type RestoreOptions struct {
+	RestoreTime    *time.Time // Желаемый момент восстановления (nil - на последний доступный момент)
+	StopAtMark     string     // Имя отметки транзакции для STOPATMARK (включая отмеченную транзакцию)
+	StopBeforeMark string     // Имя отметки транзакции для STOPBEFOREMARK (исключая отмеченную транзакцию)
+}
+
+// hasMark - Проверяет, задана ли остановка по отметке транзакции
+func (o RestoreOptions) hasMark() bool {
+	return o.StopAtMark != "" || o.StopBeforeMark != ""
+}
+
+// GetRestoreSequence - Определяет последовательность бэкапов для восстановления на указанный момент времени.
+// Цепочка включает первый журнальный бэкап, диапазон которого покрывает restoreTime, чтобы к нему можно было применить STOPAT.
+// Если задана отметка транзакции (includeAllLogs), в цепочку попадают все непрерывные журналы после базового бэкапа.
+func GetRestoreSequence(db *sql.DB, baseName string, restoreTime *time.Time, includeAllLogs bool, smbSharePath string) ([]BackupMetadata, error) {
 	// 1. Проверяем и монтируем SMB-шару при необходимости
 	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
 		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
@@ -26,86 +39,124 @@ func GetRestoreSequence(db *sql.DB, baseName string, restoreTime *time.Time, smb
 	// Формируем путь к директории бэкапов
 	backupDir := filepath.Join(smbSharePath, baseName)
 	
-	// Проверяем существование файла метаданных
-	metadataPath := filepath.Join(backupDir, "backup_metadata.json")
-	
-	// Проверяем существование файла метаданных
-	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("файл метаданных %s не найден", metadataPath)
-	}
-	
-	// Читаем файл метаданных
-	data, err := os.ReadFile(metadataPath)
+	// Читаем метаданные каталога бэкапов
+	allHeaders, err := loadBackupMetadata(backupDir)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения файла метаданных %s: %w", metadataPath, err)
-	}
-	
-	// Парсим JSON
-	var allHeaders []BackupMetadata
-	if err := json.Unmarshal(data, &allHeaders); err != nil {
-		return nil, fmt.Errorf("ошибка парсинга файла метаданных %s: %w", metadataPath, err)
+		return nil, err
 	}
 
-	// Строим цепочку восстановления
-	// Имя базы данных в BackupMetadata не содержится, но мы фильтруем по baseName вызывающем коде
-	backups := make([]BackupMetadata, len(allHeaders))
-	copy(backups, allHeaders)
+	// Строим цепочку восстановления только из бэкапов нужной базы (в каталог могли попасть бэкапы других баз)
+	backups := filterBackupsByDatabase(baseName, allHeaders)
 	
 	if len(backups) == 0 {
 		return nil, fmt.Errorf("не найдено бэкапов для базы данных: %s", baseName)
 	}
 
-	// Отфильтруем бэкапы, которые завершились до targetTime
-	var validBackups []BackupMetadata
-	for _, b := range backups {
-		if restoreTime == nil || b.End.Before(*restoreTime) || b.End.Equal(*restoreTime) {
-			validBackups = append(validBackups, b)
-		}
+	restoreChain, err := buildRestoreSequence(backups, restoreTime, includeAllLogs)
+	if err != nil {
+		return nil, err
 	}
-	if len(validBackups) == 0 {
-		return nil, fmt.Errorf("нет бэкапов до указанного времени")
+
+	// Логируем имена файлов в цепочке
+	var chainFileNames []string
+	for _, backup := range restoreChain {
+		chainFileNames = append(chainFileNames, backup.FileName)
+	}
+	logging.LogDebug(fmt.Sprintf("Цепочка восстановления для базы %s: %v", baseName, chainFileNames))
+
+	return restoreChain, nil
+}
+
+// buildRestoreSequence - Выбирает цепочку восстановления из бэкапов одной базы. Базовые бэкапы перебираются
+// от новых к старым (полный бэкап, затем его дифференциальные от последнего к отсутствию дифференциального),
+// пока цепочка не достигнет restoreTime, - так же, как строятся интервалы восстановления (analyzeBackupChains).
+// Если ни одна цепочка не достигает restoreTime, возвращается построенная цепочка с самым поздним окончанием
+// (восстановление на ближайший доступный момент); ошибка - только если цепочку не удалось построить ни от одной базы.
+func buildRestoreSequence(backups []BackupMetadata, restoreTime *time.Time, includeAllLogs bool) ([]BackupMetadata, error) {
+	// Неполные бэкапы (например, без части полос) восстановить нельзя
+	backups, _ = splitIncompleteBackups(backups)
+
+	notAfter := func(b BackupMetadata) bool {
+		return restoreTime == nil || b.End.Before(*restoreTime) || b.End.Equal(*restoreTime)
 	}
 
-	// В начале ищем последний полный бэкап (Database, IsCopyOnly == false) перед targetTime с максимальным End
+	// Полные бэкапы (Database, IsCopyOnly == false), завершившиеся до targetTime, - от новых к старым
 	var fullBackups []BackupMetadata
-	for _, b := range validBackups {
-		if b.Type == "Database" && !b.IsCopyOnly {
+	for _, b := range backups {
+		if b.Type == "Database" && !b.IsCopyOnly && notAfter(b) {
 			fullBackups = append(fullBackups, b)
 		}
 	}
 	if len(fullBackups) == 0 {
-		return nil, fmt.Errorf("нет полного бэкапа для базы")
+		for _, b := range backups {
+			if notAfter(b) {
+				return nil, fmt.Errorf("нет полного бэкапа для базы")
+			}
+		}
+		return nil, fmt.Errorf("нет бэкапов до указанного времени")
 	}
 	sort.Slice(fullBackups, func(i, j int) bool {
 		return fullBackups[i].End.AfterCT(fullBackups[j].End)
 	})
-	fullBackup := fullBackups[0]
-	restoreChain := []BackupMetadata{fullBackup}
-
-	// Далее добавляем дифференциальный бэкап, если есть, сделанный после полного и до targetTime
-	var diffBackups []BackupMetadata
-	for _, b := range validBackups {
-		if b.Type == "Database Differential" && !b.IsCopyOnly && compareLSN(b.DatabaseBackupLSN, fullBackup.FirstLSN) == 0 {
-			if b.End.AfterCT(fullBackup.End) && (restoreTime == nil || b.End.Before(*restoreTime) || b.End.Equal(*restoreTime)) {
+
+	var bestChain []BackupMetadata
+	var firstErr error
+	for _, fullBackup := range fullBackups {
+		// Дифференциальные бэкапы этого полного, сделанные до targetTime, - от новых к старым
+		var diffBackups []BackupMetadata
+		for _, b := range backups {
+			if b.Type == "Database Differential" && !b.IsCopyOnly && isChainBase(fullBackup, b) &&
+				b.End.AfterCT(fullBackup.End) && notAfter(b) {
 				diffBackups = append(diffBackups, b)
 			}
 		}
-	}
-	if len(diffBackups) > 0 {
 		sort.Slice(diffBackups, func(i, j int) bool {
 			return diffBackups[i].End.AfterCT(diffBackups[j].End)
 		})
-		diffBackup := diffBackups[0]
-		restoreChain = append(restoreChain, diffBackup)
+
+		// Варианты начала цепочки: с каждым дифференциальным бэкапом и без него
+		starts := make([][]BackupMetadata, 0, len(diffBackups)+1)
+		for _, diffBackup := range diffBackups {
+			starts = append(starts, []BackupMetadata{fullBackup, diffBackup})
+		}
+		starts = append(starts, []BackupMetadata{fullBackup})
+
+		for _, start := range starts {
+			chain, err := appendLogChain(backups, start, restoreTime, includeAllLogs)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			if restoreTime == nil || !chain[len(chain)-1].End.Before(*restoreTime) {
+				return chain, nil
+			}
+			if bestChain == nil || chain[len(chain)-1].End.AfterCT(bestChain[len(bestChain)-1].End) {
+				bestChain = chain
+			}
+		}
 	}
+	if bestChain != nil {
+		return bestChain, nil
+	}
+	return nil, firstErr
+}
+
+// appendLogChain - Дополняет начало цепочки (полный и, возможно, дифференциальный бэкап) журналами:
+// первый журнал содержит LastLSN последнего бэкапа цепочки, остальные стыкуются по LSN
+func appendLogChain(backups []BackupMetadata, start []BackupMetadata, restoreTime *time.Time, includeAllLogs bool) ([]BackupMetadata, error) {
+	fullBackup := start[0]
+	restoreChain := append([]BackupMetadata{}, start...)
 
 	// Построение цепочки журнальных бэкапов
+	// Журналы не ограничиваются restoreTime: нужен журнал, завершившийся после желаемого момента, чтобы применить STOPAT
 	lastBackup := restoreChain[len(restoreChain)-1]
 	var logBackups []BackupMetadata
-	for _, b := range validBackups {
+	for _, b := range backups {
 		if b.Type == "Transaction Log" && !b.IsCopyOnly {
 			// Проверяем, что транзакционный лог основан на том же полном бэкапе, что и цепочка
-			if compareLSN(b.DatabaseBackupLSN, fullBackup.FirstLSN) == 0 {
+			if isChainBase(fullBackup, b) {
 				// Также проверяем, что транзакционный лог был создан после последнего бэкапа в цепочке
 				if b.Start.AfterCT(lastBackup.End) || b.Start.EqualCT(lastBackup.End) {
 					logBackups = append(logBackups, b)
@@ -116,15 +167,14 @@ func GetRestoreSequence(db *sql.DB, baseName string, restoreTime *time.Time, smb
 
 	// Сортируем журнальные бэкапы по FirstLSN
 	sort.Slice(logBackups, func(i, j int) bool {
-		return compareLSN(logBackups[i].FirstLSN, logBackups[j].FirstLSN) < 0
+		return logBackups[i].FirstLSN.Compare(logBackups[j].FirstLSN) < 0
 	})
 
-	// Находим первый журнальный бэкап, который может быть использован для продолжения цепочки
-	// FirstLSN журнала может быть <= LastLSN предыдущего бэкапа, но при этом лог должен быть создан после предыдущего бэкапа
+	// Находим первый журнальный бэкап, который может быть использован для продолжения цепочки:
+	// его диапазон LSN содержит LastLSN предыдущего бэкапа
 	var firstLog *BackupMetadata
 	for i, log := range logBackups {
-		// Проверяем, что FirstLSN лога <= LastLSN предыдущего бэкапа
-		if compareLSN(log.FirstLSN, lastBackup.LastLSN) <= 0 {
+		if log.FirstLSN.Compare(lastBackup.LastLSN) <= 0 && lastBackup.LastLSN.Compare(log.LastLSN) <= 0 {
 			firstLog = &logBackups[i]
 			restoreChain = append(restoreChain, log)
 			break
@@ -137,26 +187,37 @@ func GetRestoreSequence(db *sql.DB, baseName string, restoreTime *time.Time, smb
 		// Но если восстанавливаем на момент времени окончания предыдущего бэкапа, то можно обойтись без транзакционных логов
 		if restoreTime != nil && lastBackup.End.Before(*restoreTime) {
 			return nil, fmt.Errorf("не найден подходящий транзакционный лог для продолжения цепочки восстановления")
-	}
+		}
 	}
 
 	// Добавляем остальные журнальные бэкапы, если они есть, с учетом непрерывности цепочки
 	// После добавления первого журнального бэкапа, остальные должны следовать последовательно
 	if firstLog != nil {
 		currentLSN := firstLog.LastLSN
-	for _, log := range logBackups {
+		for _, log := range logBackups {
 			// Пропускаем уже добавленный бэкап
-			if log.FileName == firstLog.FileName {
+			if log.SetKey() == firstLog.SetKey() {
 				continue
 			}
 			// Для остальных транзакционных логов: FirstLSN == LastLSN предыдущего
-			if compareLSN(log.FirstLSN, currentLSN) == 0 {
+			if log.FirstLSN.Compare(currentLSN) == 0 {
 				restoreChain = append(restoreChain, log)
 				currentLSN = log.LastLSN
 			}
 		}
 	}
 
+	// Обрезаем цепочку на первом журнале, который покрывает желаемый момент времени.
+	// При остановке по отметке транзакции оставляем все журналы, так как заранее неизвестно, в каком из них отметка.
+	if restoreTime != nil && !includeAllLogs {
+		for i, b := range restoreChain {
+			if b.Type == "Transaction Log" && !b.End.Before(*restoreTime) {
+				restoreChain = restoreChain[:i+1]
+				break
+			}
+		}
+	}
+
 	// Проверяем непрерывность цепочки по LSN
 	// Только для транзакционных логов: первый может начинаться до LastLSN предыдущего бэкапа, но остальные должны точно стыковаться
 	for i := 1; i < len(restoreChain); i++ {
@@ -164,55 +225,70 @@ func GetRestoreSequence(db *sql.DB, baseName string, restoreTime *time.Time, smb
 		curr := restoreChain[i]
 		if curr.Type == "Transaction Log" && prev.Type == "Transaction Log" {
 			// Только между транзакционными логами: FirstLSN == LastLSN предыдущего
-			if compareLSN(curr.FirstLSN, prev.LastLSN) != 0 {
+			if curr.FirstLSN.Compare(prev.LastLSN) != 0 {
 				return nil, fmt.Errorf("порвана цепочка: журнал %s (FirstLSN=%s) не стыкуется с предыдущим %s (LastLSN=%s)", 
 					curr.FileName, curr.FirstLSN, prev.FileName, prev.LastLSN)
 			}
 		}
 	}
 
-	// Логируем имена файлов в цепочке
-	var chainFileNames []string
-	for _, backup := range restoreChain {
-		chainFileNames = append(chainFileNames, backup.FileName)
-	}
-	logging.LogDebug(fmt.Sprintf("Цепочка восстановления для базы %s: %v", baseName, chainFileNames))
-
 	return restoreChain, nil
 }
 
-// StartRestore - Запускает асинхронный процесс восстановления базы данных
-func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *time.Time, smbSharePath, restorePath string) error {
-	// Проверяем, существует ли база данных на сервере
-	dbExists, err := checkDatabaseExists(db, newDBName)
-	if err != nil {
-		return fmt.Errorf("ошибка проверки существования базы данных '%s': %w", newDBName, err)
+// StartRestore - Запускает асинхронный процесс восстановления базы данных и возвращает идентификатор операции.
+// На время восстановления база блокируется для других операций (jobs.ErrDatabaseBusy).
+func StartRestore(db *sql.DB, backupBaseName, newDBName string, opts RestoreOptions, smbSharePath string, paths RestorePaths) (jobID string, err error) {
+	restoreTime := opts.RestoreTime
+
+	jobID = jobs.NewID()
+	if err := jobs.LockDatabase(newDBName, jobID, jobs.TypeRestore); err != nil {
+		return "", err
 	}
-	
-	// Если база существует, переводим её в однопользовательский режим перед восстановлением
-	if dbExists {
-		if err := SetSingleUserMode(db, newDBName); err != nil {
-			return fmt.Errorf("ошибка перевода базы '%s' в однопользовательский режим перед восстановлением: %w", newDBName, err)
+	// Блокировка освобождается горутиной восстановления; при ошибке запуска - сразу
+	started := false
+	defer func() {
+		if !started {
+			jobs.UnlockDatabase(newDBName, jobID)
 		}
+	}()
+
+	// Строим план восстановления до начала операции (тот же планировщик используется для предпросмотра),
+	// чтобы ошибки цепочки и нехватка места отклоняли запрос, а не оставляли базу в состоянии RESTORING
+	plan, err := BuildRestorePlan(db, backupBaseName, newDBName, opts, smbSharePath, paths)
+	if err != nil {
+		return "", fmt.Errorf("ошибка построения плана восстановления: %w", err)
 	}
-	
+
+	// Проверяем свободное место на целевых томах
+	if err := CheckRestoreFreeSpace(db, plan); err != nil {
+		return "", err
+	}
+
 	// Инициализация прогресса восстановления
 	// Создаем контекст для отмены операции восстановления
 	ctx, cancel := context.WithCancel(context.Background())
 
 	RestoreProgressesMutex.Lock()
 	RestoreProgresses[newDBName] = &RestoreProgress{
-		Status:      "pending",
+		JobID:       jobID,
+		BackupBaseName: backupBaseName,
+		Parameters:  restoreJobParameters(backupBaseName, opts, paths),
+		Chain:       plan.fileNames(),
+		Status:      "queued",
 		StartTime:   time.Now(),
 		TotalFiles:  0, // Будет обновлено после получения filesToRestore
-		CurrentFile: "Инициализация...",
+		CurrentFile: "Ожидание в очереди...",
+		RequestedTime: restoreTime,
 		CancelFunc:  cancel, // Сохраняем функцию отмены
 	}
 	RestoreProgressesMutex.Unlock()
+	saveRestoreJob(newDBName)
 
-	// Используем горутину, чтобы не блокировать обработчик HTTP-запросов
-	go func(ctx context.Context, cancel context.CancelFunc) { // Передаем контекст и функцию отмены
-		defer cancel() // Гарантируем вызов cancel при завершении горутины
+	// Восстановление выполняется из очереди операций, чтобы не блокировать обработчик HTTP-запросов
+	// и не превышать лимиты одновременных операций
+	run := func() {
+		defer cancel() // Гарантируем вызов cancel при завершении восстановления
+		defer jobs.UnlockDatabase(newDBName, jobID)
 
 		if restoreTime != nil {
 			logging.LogWebInfo(fmt.Sprintf("Начато асинхронное восстановление базы '%s' из бэкапа '%s' на %s", newDBName, backupBaseName, restoreTime.Format("2006-01-02 15:04:05")))
@@ -226,13 +302,15 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 		progress := RestoreProgresses[newDBName]
 		if progress != nil {
 			progress.Status = "in_progress"
+			progress.CurrentFile = "Инициализация..."
+			progress.StartTime = time.Now()
 		}
 		RestoreProgressesMutex.Unlock()
+		saveRestoreJob(newDBName)
 
-		// 1. Получение последовательности бэкапов
-		filesToRestore, err := GetRestoreSequence(db, backupBaseName, restoreTime, smbSharePath)
-		if err != nil {
-			logging.LogError(fmt.Sprintf("Ошибка получения последовательности бэкапов для %s: %v", backupBaseName, err))
+		// Если база существует, переводим её в однопользовательский режим перед восстановлением
+		if err := prepareRestoreTarget(db, newDBName); err != nil {
+			logging.LogWebError(err.Error())
 			RestoreProgressesMutex.Lock()
 			if progress != nil {
 				progress.Status = "failed"
@@ -240,71 +318,33 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 				progress.EndTime = time.Now()
 			}
 			RestoreProgressesMutex.Unlock()
+			saveRestoreJob(newDBName)
 			return
 		}
 
-		// Обновляем общее количество файлов
-		RestoreProgressesMutex.Lock()
-		if progress != nil {
-			progress.TotalFiles = len(filesToRestore)
-		}
-		RestoreProgressesMutex.Unlock()
-
-		// 2. Определение первого файла и логических имен
-		// Используем FileName из BackupMetadata
-		startFile := filesToRestore[0]
-		
-		// Получаем логические имена файлов из первого файла в цепочке (startFile)
-		backupFilePath := filepath.Join(smbSharePath, backupBaseName, startFile.FileName)
-		logicalFiles, err := GetBackupLogicalFiles(db, backupFilePath)
-		if err != nil {
-			logging.LogError(fmt.Sprintf("Ошибка получения логических имен файлов бэкапа для %s: %v", backupBaseName, err))
-			RestoreProgressesMutex.Lock()
-			if progress != nil {
-				progress.Status = "failed"
-				progress.Error = err.Error()
-				progress.EndTime = time.Now()
-			}
-			RestoreProgressesMutex.Unlock()
-			return
+		if restoreTime != nil && plan.ReachedTime != nil && !plan.ExactTime {
+			logging.LogWebInfo(fmt.Sprintf("Момент %s недостижим для базы '%s', будет использован ближайший доступный: %s",
+				restoreTime.Format("2006-01-02 15:04:05"), newDBName, plan.ReachedTime.Format("2006-01-02 15:04:05")))
 		}
-		logging.LogDebug(fmt.Sprintf("Успешно получены логические имена файлов из бэкапа: %+v", logicalFiles))
 
-		// 3. Формируем MOVE-часть команды RESTORE
-		var moveClause string
-		var moveParts []string
-		
-		for _, logicalFile := range logicalFiles {
-			var ext string
-			var physicalFileName string 
-
-			switch logicalFile.Type {
-			case "DATA":
-				ext = ".mdf"
-				// Формируем имя файла как NewDBName.mdf
-				physicalFileName = fmt.Sprintf("%s%s", newDBName, ext)
-			case "LOG":
-				ext = ".ldf"
-				// Формируем имя файла как NewDBName_log.ldf
-				physicalFileName = fmt.Sprintf("%s_log%s", newDBName, ext)
-			default:
-				// Если тип файла неизвестен, просто пропускаем
-				continue
+		// Обновляем общее количество файлов и достигаемый момент времени
+		RestoreProgressesMutex.Lock()
+		if progress != nil {
+			progress.TotalFiles = len(plan.Files)
+			progress.fileSizes = make([]int64, len(plan.Files))
+			for i, file := range plan.Files {
+				progress.fileSizes[i] = file.Size
 			}
-
-			// Формируем полный путь к физическому файлу
-			physicalPath := filepath.Join(restorePath, physicalFileName) 
-
-			moveParts = append(moveParts, fmt.Sprintf("MOVE N'%s' TO N'%s'", logicalFile.LogicalName, physicalPath))
+			progress.ReachedTime = plan.ReachedTime
+			progress.ExactTime = plan.ExactTime
 		}
+		RestoreProgressesMutex.Unlock()
+		saveRestoreJob(newDBName)
 
-		moveClause = strings.Join(moveParts, ", ")
-		
-		// 4. Выполнение восстановления
-		for i, file := range filesToRestore {
+		// Выполнение восстановления по плану
+		for i, file := range plan.Files {
 			// Проверяем контекст на отмену перед каждым шагом восстановления
-			select {
-			case <-ctx.Done():
+			markCancelled := func() {
 				logging.LogError(fmt.Sprintf("Восстановление базы '%s' отменено пользователем.", newDBName))
 				RestoreProgressesMutex.Lock()
 				if progress != nil {
@@ -313,70 +353,44 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 					progress.EndTime = time.Now()
 				}
 				RestoreProgressesMutex.Unlock()
+				saveRestoreJob(newDBName)
 				// Горутина восстановления просто завершается, удаление БД будет выполнено в cancelRestoreProcess
 				// Не пытаемся перевести в EMERGENCY или удалять здесь.
+			}
+			select {
+			case <-ctx.Done():
+				markCancelled()
 				return
 			default:
 				// Продолжаем, если контекст не отменен
 			}
 
-			// Обновляем прогресс перед выполнением каждого RESTORE
+			// Обновляем прогресс перед выполнением каждого RESTORE. Отмена проверяется под той же блокировкой,
+			// под которой CancelRestoreProcess решает, удалять ли базу (RestoreStarted)
 			RestoreProgressesMutex.Lock()
+			if ctx.Err() != nil {
+				RestoreProgressesMutex.Unlock()
+				markCancelled()
+				return
+			}
 			if progress != nil {
 				progress.CompletedFiles = i
 				progress.CurrentFile = filepath.Base(file.FileName)
-				if progress.TotalFiles > 0 {
-					progress.Percentage = (i * 100) / progress.TotalFiles
-				}
+				progress.CurrentFilePercent = 0
+				progress.EstimatedCompletionTime = nil
+				progress.updatePercentage()
+				progress.RestoreStarted = true
 			}
 			RestoreProgressesMutex.Unlock()
-
-			isFirstFile := i == 0
-			isLastFile := i == len(filesToRestore)-1
-			var restoreQuery string
-			
-			var recoveryOption string
-			// file.Position больше не доступен, так как мы используем BackupMetadata
-			// Предполагаем, что каждый файл содержит один бэкап на позиции 1
-			filePositionClause := ", FILE = 1"
-			
-			if isLastFile {
-				// Для последного файла всегда используем RECOVERY.
-				// STOPAT не используется, так как точное время восстановления может не совпадать с границей транзакции.
-				recoveryOption = "RECOVERY"
-				logging.LogDebug("Последний файл в цепочке, используется RECOVERY.")
-			} else {
-				// Не последний бэкап: всегда используем NORECOVERY без STOPAT
-				recoveryOption = "NORECOVERY"
-				logging.LogDebug(fmt.Sprintf("Промежуточный файл в цепочке, используется NORECOVERY. Тип: %s", file.Type))
-			}
-			
-			// Формирование команды RESTORE
-			// Создаем полный путь к файлу бэкапа через smbSharePath
-			backupFilePath := filepath.Join(smbSharePath, backupBaseName, file.FileName)
-			
-			if isFirstFile {
-				// Первый файл (FULL/DIFF) использует MOVE и REPLACE
-				restoreQuery = fmt.Sprintf("RESTORE DATABASE [%s] FROM DISK = N'%s' WITH %s, REPLACE%s, %s, STATS = 10", newDBName, backupFilePath, moveClause, filePositionClause, recoveryOption)
-			} else {
-				// Последующие файлы (DIFF/TRN). MOVE не нужен.
-				switch file.Type {
-				case "Transaction Log":
-					// LOG бэкап. 
-					restoreQuery = fmt.Sprintf("RESTORE LOG [%s] FROM DISK = N'%s' WITH %s%s, STATS = 10", newDBName, backupFilePath, recoveryOption, filePositionClause)
-				case "Database Differential":
-					// Дифференциальный бэкап. Используем RESTORE DATABASE
-					restoreQuery = fmt.Sprintf("RESTORE DATABASE [%s] FROM DISK = N'%s' WITH %s%s, STATS = 10", newDBName, backupFilePath, recoveryOption, filePositionClause)
-				case "Database": // FULL, если он не первый
-					// Используем RESTORE DATABASE
-					restoreQuery = fmt.Sprintf("RESTORE DATABASE [%s] FROM DISK = N'%s' WITH %s%s, STATS = 10", newDBName, backupFilePath, recoveryOption, filePositionClause)
-				}
+			if i == 0 {
+				saveRestoreJob(newDBName)
 			}
-			
-			logging.LogDebug(fmt.Sprintf("Выполнение RESTORE (%d/%d): %s", i+1, len(filesToRestore), restoreQuery))
+
+			restoreQuery := plan.Statements[i]
+			logging.LogDebug(fmt.Sprintf("Выполнение RESTORE (%d/%d): %s", i+1, len(plan.Statements), restoreQuery))
 			
 			if _, err := db.Exec(restoreQuery); err != nil {
-				logging.LogDebug(fmt.Sprintf("Прерывание RESTORE для %s (файл: %s): %v", newDBName, backupFilePath, err))
+				logging.LogDebug(fmt.Sprintf("Прерывание RESTORE для %s (файл: %s): %v", newDBName, file.FileName, err))
 				// Обновляем статус на "failed", удаление БД будет выполнено в cancelRestoreProcess
 				RestoreProgressesMutex.Lock()
 				if progress != nil {
@@ -385,6 +399,7 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 					progress.EndTime = time.Now()
 				}
 				RestoreProgressesMutex.Unlock()
+				saveRestoreJob(newDBName)
 				return
 			}
 		}
@@ -405,6 +420,7 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 				progress.EndTime = time.Now()
 			}
 			RestoreProgressesMutex.Unlock()
+			saveRestoreJob(newDBName)
 			return
 		}
 		
@@ -422,6 +438,7 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 				progress.EndTime = time.Now()
 			}
 			RestoreProgressesMutex.Unlock()
+			saveRestoreJob(newDBName)
 			return
 	}
 		
@@ -430,53 +447,202 @@ func StartRestore(db *sql.DB, backupBaseName, newDBName string, restoreTime *tim
 			progress.Status = "completed"
 			progress.CompletedFiles = progress.TotalFiles // Все файлы завершены
 			progress.Percentage = 100
+			progress.CurrentFilePercent = 0
+			progress.EstimatedCompletionTime = nil
 			progress.EndTime = time.Now()
 		}
 	RestoreProgressesMutex.Unlock()
+		saveRestoreJob(newDBName)
+
+	}
+
+	// Отмена до запуска: снимаем блокировку и убираем запись о прогрессе (база не затрагивалась)
+	onCancel := func() {
+		cancel()
+		RestoreProgressesMutex.Lock()
+		if progress := RestoreProgresses[newDBName]; progress != nil && progress.JobID == jobID {
+			progress.Status = "cancelled"
+			progress.Error = "Отменено пользователем до запуска"
+			progress.EndTime = time.Now()
+		}
+		RestoreProgressesMutex.Unlock()
+		saveRestoreJob(newDBName)
+		RestoreProgressesMutex.Lock()
+		if progress := RestoreProgresses[newDBName]; progress != nil && progress.JobID == jobID {
+			delete(RestoreProgresses, newDBName)
+		}
+		RestoreProgressesMutex.Unlock()
+		jobs.UnlockDatabase(newDBName, jobID)
+		logging.LogWebInfo(fmt.Sprintf("Восстановление базы '%s' отменено до запуска", newDBName))
+	}
 
-	}(ctx, cancel) // Передаем контекст и функцию отмены в горутину
+	started = true
+	if position := jobs.Enqueue(jobID, jobs.TypeRestore, newDBName, getServerName(db), run, onCancel); position > 0 {
+		logging.LogWebInfo(fmt.Sprintf("Восстановление базы '%s' поставлено в очередь, позиция %d", newDBName, position))
+	}
+
+	return jobID, nil
+}
 
+// prepareRestoreTarget - Переводит существующую базу в однопользовательский режим перед восстановлением
+func prepareRestoreTarget(db *sql.DB, dbName string) error {
+	dbExists, err := checkDatabaseExists(db, dbName)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки существования базы данных '%s': %w", dbName, err)
+	}
+	if dbExists {
+		if err := SetSingleUserMode(db, dbName); err != nil {
+			return fmt.Errorf("ошибка перевода базы '%s' в однопользовательский режим перед восстановлением: %w", dbName, err)
+		}
+	}
 	return nil
 }
 
-// GetRestoreProgress - Возвращает текущий прогресс восстановления для указанной БД
-func GetRestoreProgress(dbName string) *RestoreProgress {
+// GetRestoreProgress - Возвращает текущий прогресс восстановления для указанной БД.
+// Для выполняющегося восстановления процент текущего файла берется из sys.dm_exec_requests.
+func GetRestoreProgress(db *sql.DB, dbName string) *RestoreProgress {
 	RestoreProgressesMutex.Lock()
-	defer RestoreProgressesMutex.Unlock()
-	return RestoreProgresses[dbName]
+	progress := RestoreProgresses[dbName]
+	if progress == nil {
+		RestoreProgressesMutex.Unlock()
+		return nil
+	}
+	status := progress.Status
+	if status == "queued" {
+		progress.QueuePosition = jobs.QueuePosition(progress.JobID)
+	}
+	RestoreProgressesMutex.Unlock()
+
+	if status != "in_progress" {
+		return progress
+	}
+
+	// Запрос к DMV выполняется без блокировки, чтобы не задерживать обновления статуса горутинами восстановления
+	query := `
+		SELECT r.percent_complete, r.estimated_completion_time, r.session_id, t.text
+		FROM sys.dm_exec_requests r
+		CROSS APPLY sys.dm_exec_sql_text(r.sql_handle) t
+		WHERE r.command LIKE '%RESTORE%';
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return progress
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var percentComplete float64
+		var estimatedCompletionMs int64
+		var sessionID int
+		var commandText sql.NullString
+		if err := rows.Scan(&percentComplete, &estimatedCompletionMs, &sessionID, &commandText); err != nil {
+			continue
+		}
+
+		// Проверяем, содержит ли текст команды имя целевой базы данных (RESTORE DATABASE или RESTORE LOG)
+		if commandText.Valid && (strings.Contains(commandText.String, fmt.Sprintf("DATABASE [%s]", dbName)) ||
+			strings.Contains(commandText.String, fmt.Sprintf("LOG [%s]", dbName))) {
+			RestoreProgressesMutex.Lock()
+			// За время запроса восстановление могло перейти к следующему файлу или завершиться
+			if progress.Status == "in_progress" {
+				progress.CurrentFilePercent = percentComplete
+				progress.SessionID = sessionID
+				if estimatedCompletionMs > 0 {
+					eta := time.Now().Add(time.Duration(estimatedCompletionMs) * time.Millisecond)
+					progress.EstimatedCompletionTime = &eta
+				}
+				progress.updatePercentage()
+			}
+			RestoreProgressesMutex.Unlock()
+			break
+		}
+	}
+
+	return progress
 }
 
 // CancelRestoreProcess - Отмена восстановления
 func CancelRestoreProcess(db *sql.DB, dbName string) error {
 	RestoreProgressesMutex.Lock()
 	progress, exists := RestoreProgresses[dbName]
+	var jobID string
+	if exists {
+		jobID = progress.JobID
+	}
 	RestoreProgressesMutex.Unlock()
 
 	if !exists {
 		return fmt.Errorf("восстановление базы '%s' не найдено", dbName)
 	}
 
-	switch progress.Status {
+	// Запись удаляется под мьютексом (RestoreProgresses обходят liveJobs и рассылка прогресса)
+	// и только если она все еще относится к отменяемой операции
+	removeProgress := func() {
+		RestoreProgressesMutex.Lock()
+		if current := RestoreProgresses[dbName]; current != nil && current.JobID == jobID {
+			delete(RestoreProgresses, dbName)
+		}
+		RestoreProgressesMutex.Unlock()
+	}
+
+	// Отмена выполняется от имени операции восстановления: пока оно идет, блокировка уже принадлежит ему,
+	// а если база занята другой операцией (например, бэкапом), отмена отклоняется
+	if err := jobs.LockDatabase(dbName, jobID, jobs.TypeRestore); err != nil {
+		return err
+	}
+	defer jobs.UnlockDatabase(dbName, jobID)
+
+	// Состояние читается под мьютексом: горутина восстановления меняет его одновременно
+	RestoreProgressesMutex.Lock()
+	status, restoreStarted, cancelFunc := progress.Status, progress.RestoreStarted, progress.CancelFunc
+	RestoreProgressesMutex.Unlock()
+
+	switch status {
+	case "queued":
+		// Восстановление еще не начиналось - база не затрагивалась, просто убираем операцию из очереди
+		return jobs.CancelQueued(jobID)
 	case "failed", "cancelled":
-		delete(RestoreProgresses, dbName)
+		removeProgress()
+		if !restoreStarted {
+			// Ошибка до первой команды RESTORE (например, перевода в SINGLE_USER): существующая база не изменялась
+			return nil
+		}
 		return DeleteDatabase(db, dbName)
 	case "completed":
 		// При успешном завершении не удаляем базу, а просто удаляем запись о процессе
-		delete(RestoreProgresses, dbName)
+		removeProgress()
 	return nil
 	}
 
-	if progress.CancelFunc != nil {
-		progress.CancelFunc()
-	} else {
+	if cancelFunc == nil {
 		return fmt.Errorf("невозможно отменить восстановление для базы '%s': CancelFunc не установлен", dbName)
 	}
 
+	// Фиксируем отмену в хранилище операций до удаления записи о прогрессе
+	RestoreProgressesMutex.Lock()
+	if progress.Status == "completed" || progress.Status == "failed" || progress.Status == "cancelled" {
+		// Восстановление завершилось, пока проверялось его состояние: базу не трогаем
+		status := progress.Status
+		RestoreProgressesMutex.Unlock()
+		return fmt.Errorf("восстановление базы '%s' уже завершено (%s), повторите запрос", dbName, status)
+	}
+	cancelFunc()
+	restoreStarted = progress.RestoreStarted
+	progress.Status = "cancelled"
+	progress.Error = "Отменено пользователем"
+	progress.EndTime = time.Now()
+	RestoreProgressesMutex.Unlock()
+	saveRestoreJob(dbName)
+
 	// Сразу пытаемся убить сессии и удалить базу, без таймаута и ожидания
 	if err := KillRestoreSession(db, dbName); err != nil {
 	}
 	
-	delete(RestoreProgresses, dbName)
+	removeProgress()
+	if !restoreStarted {
+		// Отмена до первой команды RESTORE: горутина восстановления завершится по контексту, не затронув базу
+		return nil
+	}
 	return DeleteDatabase(db, dbName)
 }
 
@@ -504,8 +670,9 @@ func KillRestoreSession(db *sql.DB, dbName string) error {
 			continue
 		}
 
-		// Проверяем, содержит ли текст команды имя целевой базы данных
-		if commandText.Valid && strings.Contains(commandText.String, fmt.Sprintf("DATABASE [%s]", dbName)) {
+		// Проверяем, содержит ли текст команды имя целевой базы данных (RESTORE DATABASE или RESTORE LOG)
+		if commandText.Valid && (strings.Contains(commandText.String, fmt.Sprintf("DATABASE [%s]", dbName)) ||
+			strings.Contains(commandText.String, fmt.Sprintf("LOG [%s]", dbName))) {
 			sessionIDsToKill = append(sessionIDsToKill, sessionID)
 	}
 	}
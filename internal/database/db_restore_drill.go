@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/jobs"
+	"github.com/freezzorg/SQLManager/internal/logging"
+)
+
+// Префикс имен временных баз тестового восстановления
+const restoreTestDBPrefix = "SQLManager_test_"
+
+// RestoreTestOptions - Параметры тестового восстановления
+type RestoreTestOptions struct {
+	ScheduleID string // Идентификатор расписания, запустившего тест
+}
+
+// RestoreTestReportEntry - Сводка тестовых восстановлений по каталогу бэкапов
+type RestoreTestReportEntry struct {
+	Directory     string                  `json:"directory"`
+	BackupSet     string                  `json:"backupSet,omitempty"` // Полный бэкап, от которого строилась цепочка
+	BackupFile    string                  `json:"backupFile,omitempty"`
+	BackupSetGUID string                  `json:"backupSetGuid,omitempty"`
+	LastSuccess   *jobs.RestoreTestResult `json:"lastSuccess,omitempty"` // Последний успешный тест
+	LastRun       *jobs.RestoreTestResult `json:"lastRun,omitempty"`     // Последний тест (в том числе неуспешный)
+}
+
+// restoreTestDBName - Формирует уникальное имя временной базы для тестового восстановления каталога
+func restoreTestDBName(dirName string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, dirName)
+	return fmt.Sprintf("%s%s_%s", restoreTestDBPrefix, name, time.Now().Format("20060102150405"))
+}
+
+// sqlErrorMessages - Возвращает все сообщения об ошибках SQL Server (DBCC CHECKDB сообщает о каждом повреждении отдельно)
+func sqlErrorMessages(err error) string {
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) && len(sqlErr.All) > 1 {
+		messages := make([]string, 0, len(sqlErr.All))
+		for _, e := range sqlErr.All {
+			messages = append(messages, e.Message)
+		}
+		return strings.Join(messages, "\n")
+	}
+	return err.Error()
+}
+
+// StartRestoreTest - Ставит в очередь тестовое восстановление последней цепочки каталога бэкапов во временную базу,
+// проверку DBCC CHECKDB WITH NO_INFOMSGS и удаление базы. Длительность, размеры и ошибки сохраняются в хранилище.
+func StartRestoreTest(db *sql.DB, dirName string, opts RestoreTestOptions, smbSharePath string, paths RestorePaths) (jobID string, err error) {
+	testDBName := restoreTestDBName(dirName)
+
+	plan, err := BuildRestorePlan(db, dirName, testDBName, RestoreOptions{}, smbSharePath, paths)
+	if err != nil {
+		return "", err
+	}
+	if err := CheckRestoreFreeSpace(db, plan); err != nil {
+		return "", err
+	}
+
+	jobID = jobs.NewID()
+	if err := jobs.LockDatabase(testDBName, jobID, jobs.TypeRestoreTest); err != nil {
+		return "", err
+	}
+
+	params := map[string]string{"backupBaseName": dirName}
+	if opts.ScheduleID != "" {
+		params["schedule"] = opts.ScheduleID
+	}
+	job := &jobs.Job{
+		ID:         jobID,
+		Type:       jobs.TypeRestoreTest,
+		Database:   testDBName,
+		Parameters: params,
+		Status:     "queued",
+		StartTime:  time.Now(),
+		Chain:      plan.fileNames(),
+	}
+	saveJob := func() {
+		if err := jobs.Save(job); err != nil {
+			logging.LogError(fmt.Sprintf("Ошибка сохранения операции тестового восстановления каталога '%s': %v", dirName, err))
+		}
+	}
+	saveJob()
+
+	run := func() {
+		defer jobs.UnlockDatabase(testDBName, jobID)
+
+		result := &jobs.RestoreTestResult{
+			JobID:         jobID,
+			Directory:     dirName,
+			DatabaseName:  testDBName,
+			Chain:         job.Chain,
+			BackupSet:     plan.Files[0].SetKey(),
+			BackupFile:    plan.Files[0].FileName,
+			BackupSetGUID: plan.Files[0].BackupSetGUID,
+			StartTime:     time.Now(),
+			BackupSize:    plan.TotalSize,
+		}
+		job.Status = "in_progress"
+		job.StartTime = result.StartTime
+		saveJob()
+		logging.LogWebInfo(fmt.Sprintf("Начато тестовое восстановление каталога '%s' в базу '%s'", dirName, testDBName))
+
+		// Шаги: команды RESTORE и DBCC CHECKDB
+		totalSteps := len(plan.Statements) + 1
+		for i, statement := range plan.Statements {
+			logging.LogDebug(fmt.Sprintf("Тестовое восстановление (%d/%d): %s", i+1, len(plan.Statements), statement))
+			if _, err := db.Exec(statement); err != nil {
+				result.Error = fmt.Sprintf("Ошибка восстановления файла %s: %v", plan.Files[i].FileName, err)
+				break
+			}
+			job.Percentage = (i + 1) * 100 / totalSteps
+			saveJob()
+		}
+		result.RestoreSeconds = time.Since(result.StartTime).Seconds()
+
+		if result.Error == "" {
+			if sizes, err := getDatabaseFileSizes(db, testDBName); err == nil {
+				for _, size := range sizes {
+					result.DatabaseSize += size
+				}
+			} else {
+				logging.LogError(err.Error())
+			}
+
+			checkStart := time.Now()
+			if _, err := db.Exec(fmt.Sprintf("DBCC CHECKDB ([%s]) WITH NO_INFOMSGS", testDBName)); err != nil {
+				result.Error = fmt.Sprintf("DBCC CHECKDB обнаружил ошибки:\n%s", sqlErrorMessages(err))
+			}
+			result.CheckDBSeconds = time.Since(checkStart).Seconds()
+		}
+
+		// Временная база удаляется в любом случае (в том числе оставшаяся в состоянии RESTORING)
+		if exists, err := checkDatabaseExists(db, testDBName); err != nil || exists {
+			if err := DeleteDatabase(db, testDBName); err != nil {
+				result.DropError = err.Error()
+			}
+		}
+
+		result.EndTime = time.Now()
+		result.DurationSeconds = result.EndTime.Sub(result.StartTime).Seconds()
+		result.Success = result.Error == ""
+		if err := jobs.SaveRestoreTestResult(result); err != nil {
+			logging.LogError(fmt.Sprintf("Ошибка сохранения результата тестового восстановления каталога '%s': %v", dirName, err))
+		}
+
+		job.EndTime = result.EndTime
+		if result.Success {
+			job.Status = "completed"
+			job.Percentage = 100
+			logging.LogWebInfo(fmt.Sprintf("Тестовое восстановление каталога '%s' успешно: восстановление %.0f с, DBCC CHECKDB %.0f с, размер базы %s",
+				dirName, result.RestoreSeconds, result.CheckDBSeconds, formatBytes(result.DatabaseSize)))
+		} else {
+			job.Status = "failed"
+			job.Error = result.Error
+			logging.LogWebError(fmt.Sprintf("Тестовое восстановление каталога '%s' не прошло: %s", dirName, result.Error))
+		}
+		if result.DropError != "" {
+			logging.LogWebError(fmt.Sprintf("Не удалось удалить временную базу '%s': %s", testDBName, result.DropError))
+		}
+		saveJob()
+	}
+
+	onCancel := func() {
+		job.Status = "cancelled"
+		job.Error = "Отменено пользователем до запуска"
+		job.EndTime = time.Now()
+		saveJob()
+		jobs.UnlockDatabase(testDBName, jobID)
+		logging.LogWebInfo(fmt.Sprintf("Тестовое восстановление каталога '%s' отменено до запуска", dirName))
+	}
+
+	if position := jobs.Enqueue(jobID, jobs.TypeRestoreTest, testDBName, getServerName(db), run, onCancel); position > 0 {
+		logging.LogWebInfo(fmt.Sprintf("Тестовое восстановление каталога '%s' поставлено в очередь, позиция %d", dirName, position))
+	}
+	return jobID, nil
+}
+
+// GetRestoreTestReport - Возвращает по каждому набору бэкапа (полному бэкапу, от которого строилась цепочка)
+// последний успешный и последний выполненный тест восстановления. Результаты прежних версий без сведений
+// о наборе группируются по каталогу.
+func GetRestoreTestReport() ([]RestoreTestReportEntry, error) {
+	results, err := jobs.ListRestoreTestResults()
+	if err != nil {
+		return nil, err
+	}
+
+	// Результаты отсортированы от новых к старым - первый встреченный и есть последний
+	entries := make(map[string]*RestoreTestReportEntry)
+	var order []string
+	for _, result := range results {
+		setKey := result.BackupSetGUID
+		if setKey == "" {
+			setKey = result.BackupSet
+		}
+		key := result.Directory + "/" + strings.ToUpper(setKey)
+		entry, ok := entries[key]
+		if !ok {
+			entry = &RestoreTestReportEntry{
+				Directory:     result.Directory,
+				BackupSet:     result.BackupSet,
+				BackupFile:    result.BackupFile,
+				BackupSetGUID: result.BackupSetGUID,
+				LastRun:       result,
+			}
+			entries[key] = entry
+			order = append(order, key)
+		}
+		if result.Success && entry.LastSuccess == nil {
+			entry.LastSuccess = result
+		}
+	}
+
+	report := make([]RestoreTestReportEntry, 0, len(order))
+	for _, key := range order {
+		report = append(report, *entries[key])
+	}
+	return report, nil
+}
@@ -0,0 +1,383 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/logging"
+)
+
+// RestorePaths - Целевые каталоги и переопределения путей для файлов восстанавливаемой базы
+type RestorePaths struct {
+	DataPath      string            // Каталог для файлов данных, FILESTREAM и полнотекстовых каталогов
+	LogPath       string            // Каталог для файлов журнала
+	FileOverrides map[string]string // Полный целевой путь по логическому имени файла (необязательно)
+}
+
+// RestoreFileTarget - Целевой путь для логического файла восстанавливаемой базы
+type RestoreFileTarget struct {
+	LogicalName string `json:"logicalName"`
+	Type        string `json:"type"`       // DATA, LOG, FILESTREAM, FULLTEXT
+	TargetPath  string `json:"targetPath"` // Путь, указываемый в MOVE
+	Size        int64  `json:"size"`       // Размер файла в байтах (по RESTORE FILELISTONLY)
+}
+
+// RestorePlanFile - Файл цепочки восстановления вместе с размером на диске
+type RestorePlanFile struct {
+	BackupMetadata
+	Size int64 `json:"Size"` // Размер файла бэкапа на диске в байтах
+}
+
+// RestorePlan - План восстановления: цепочка файлов и команды RESTORE, которые будут выполнены
+type RestorePlan struct {
+	BackupBaseName string              `json:"backupBaseName"`
+	NewDBName      string              `json:"newDbName"`
+	RequestedTime  *time.Time          `json:"requestedTime,omitempty"` // Желаемый момент восстановления
+	ReachedTime    *time.Time          `json:"reachedTime,omitempty"`   // Момент, который фактически будет достигнут (nil при остановке по отметке)
+	ExactTime      bool                `json:"exactTime"`               // true - желаемый момент достигается точно
+	Files          []RestorePlanFile   `json:"files"`
+	TotalSize      int64               `json:"totalSize"`     // Суммарный размер файлов цепочки в байтах
+	Targets        []RestoreFileTarget `json:"targets"`       // Целевые пути файлов базы (MOVE)
+	RequiredSpace  int64               `json:"requiredSpace"` // Место, необходимое для файлов базы, в байтах
+	Statements     []string            `json:"statements"`    // Команды RESTORE в порядке выполнения
+}
+
+// fileNames - Возвращает имена файлов цепочки плана в порядке восстановления
+func (p *RestorePlan) fileNames() []string {
+	names := make([]string, 0, len(p.Files))
+	for _, file := range p.Files {
+		names = append(names, file.FileName)
+	}
+	return names
+}
+
+// resolveRestorePoint - Определяет момент времени, который фактически будет достигнут цепочкой восстановления.
+// Возвращает exact = true, если желаемый момент достижим точно (через STOPAT на последнем журнале или совпадение с End).
+func resolveRestorePoint(chain []BackupMetadata, restoreTime *time.Time) (time.Time, bool) {
+	last := chain[len(chain)-1]
+	if restoreTime == nil {
+		return last.End.Time, true
+	}
+	if last.Type == "Transaction Log" && !last.End.Before(*restoreTime) {
+		return *restoreTime, true
+	}
+	if last.End.Equal(*restoreTime) {
+		return *restoreTime, true
+	}
+	// Желаемый момент лежит за пределами непрерывной цепочки - используем ближайший достижимый момент
+	return last.End.Time, false
+}
+
+// normalizeMark - Приводит отметку вида "lsn:<LSN>" к десятичной форме LSN, которую принимает SQL Server
+// (LSN можно указать и в форме vlf:block:slot); имена транзакций возвращаются без изменений
+func normalizeMark(mark string) string {
+	if !strings.HasPrefix(strings.ToLower(mark), "lsn:") {
+		return mark
+	}
+	lsn, err := ParseLSN(mark[len("lsn:"):])
+	if err != nil || lsn.IsZero() {
+		return mark
+	}
+	return "lsn:" + lsn.String()
+}
+
+// buildStopClause - Формирует часть WITH для остановки восстановления журнала (STOPAT/STOPATMARK/STOPBEFOREMARK)
+func buildStopClause(opts RestoreOptions, stopAt *time.Time) string {
+	var afterClause string
+	if opts.RestoreTime != nil {
+		afterClause = fmt.Sprintf(" AFTER N'%s'", opts.RestoreTime.Format("2006-01-02T15:04:05"))
+	}
+
+	switch {
+	case opts.StopAtMark != "":
+		return fmt.Sprintf(", STOPATMARK = N'%s'%s", normalizeMark(opts.StopAtMark), afterClause)
+	case opts.StopBeforeMark != "":
+		return fmt.Sprintf(", STOPBEFOREMARK = N'%s'%s", normalizeMark(opts.StopBeforeMark), afterClause)
+	case stopAt != nil:
+		return fmt.Sprintf(", STOPAT = N'%s'", stopAt.Format("2006-01-02T15:04:05"))
+	}
+	return ""
+}
+
+// physicalFileExt - Возвращает расширение исходного физического файла (путь может быть в формате Windows)
+func physicalFileExt(physicalName string) string {
+	name := physicalName
+	if idx := strings.LastIndexAny(name, `/\`); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		return name[idx:]
+	}
+	return ""
+}
+
+// sanitizeFileNamePart - Заменяет в логическом имени символы, недопустимые в имени файла
+func sanitizeFileNamePart(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// buildMoveTargets - Определяет целевые пути для логических файлов бэкапа (для MOVE).
+// Первичный файл данных получает имя NewDBName.mdf, первый файл журнала - NewDBName_log.ldf,
+// остальные файлы - NewDBName_<логическое имя> с исходным расширением. Имена гарантированно уникальны.
+// Файлы журнала размещаются в paths.LogPath, остальные - в paths.DataPath; paths.FileOverrides имеют приоритет.
+func buildMoveTargets(logicalFiles []BackupLogicalFile, newDBName string, paths RestorePaths) ([]RestoreFileTarget, error) {
+	// Проверяем, что все переопределения относятся к существующим логическим файлам
+	for logicalName := range paths.FileOverrides {
+		found := false
+		for _, logicalFile := range logicalFiles {
+			if strings.EqualFold(logicalFile.LogicalName, logicalName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("логический файл '%s' из переопределения путей не найден в бэкапе", logicalName)
+		}
+	}
+
+	// Пути из переопределений занимаются заранее: сгенерированные имена не должны с ними совпадать
+	usedPaths := make(map[string]string)
+	for logicalName, overridePath := range paths.FileOverrides {
+		key := targetPathKey(overridePath)
+		if other, exists := usedPaths[key]; exists {
+			return nil, fmt.Errorf("логическим файлам '%s' и '%s' задан один и тот же путь %s", other, logicalName, overridePath)
+		}
+		usedPaths[key] = logicalName
+	}
+
+	var targets []RestoreFileTarget
+	usedNames := make(map[string]bool)
+	primaryDataAssigned, primaryLogAssigned := false, false
+
+	for _, logicalFile := range logicalFiles {
+		ext := physicalFileExt(logicalFile.PhysicalName)
+		var physicalFileName string
+
+		switch logicalFile.Type {
+		case "DATA":
+			if !primaryDataAssigned {
+				// Первичный файл данных: NewDBName.mdf
+				primaryDataAssigned = true
+				if ext == "" {
+					ext = ".mdf"
+				}
+				physicalFileName = newDBName + ext
+			} else {
+				// Дополнительные файлы данных: NewDBName_<логическое имя>.ndf
+				if ext == "" {
+					ext = ".ndf"
+				}
+				physicalFileName = fmt.Sprintf("%s_%s%s", newDBName, sanitizeFileNamePart(logicalFile.LogicalName), ext)
+			}
+		case "LOG":
+			if ext == "" {
+				ext = ".ldf"
+			}
+			if !primaryLogAssigned {
+				// Первый файл журнала: NewDBName_log.ldf
+				primaryLogAssigned = true
+				physicalFileName = fmt.Sprintf("%s_log%s", newDBName, ext)
+			} else {
+				physicalFileName = fmt.Sprintf("%s_%s%s", newDBName, sanitizeFileNamePart(logicalFile.LogicalName), ext)
+			}
+		case "FILESTREAM", "FULLTEXT":
+			// Контейнеры FILESTREAM и полнотекстовые каталоги - это каталоги, расширение не используется
+			ext = ""
+			physicalFileName = fmt.Sprintf("%s_%s", newDBName, sanitizeFileNamePart(logicalFile.LogicalName))
+		default:
+			continue
+		}
+
+		// Гарантируем уникальность целевых путей (без учета регистра)
+		if usedNames[strings.ToLower(physicalFileName)] {
+			base := strings.TrimSuffix(physicalFileName, ext)
+			physicalFileName = fmt.Sprintf("%s_%d%s", base, logicalFile.FileID, ext)
+		}
+		usedNames[strings.ToLower(physicalFileName)] = true
+
+		// Формируем полный путь к физическому файлу
+		targetDir := paths.DataPath
+		if logicalFile.Type == "LOG" {
+			targetDir = paths.LogPath
+		}
+		physicalPath := filepath.Join(targetDir, physicalFileName)
+		overridden := false
+		for logicalName, overridePath := range paths.FileOverrides {
+			if strings.EqualFold(logicalFile.LogicalName, logicalName) {
+				physicalPath = overridePath
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			if _, exists := usedPaths[targetPathKey(physicalPath)]; exists {
+				physicalPath = filepath.Join(targetDir, fmt.Sprintf("%s_%d%s", strings.TrimSuffix(physicalFileName, ext), logicalFile.FileID, ext))
+			}
+			if other, exists := usedPaths[targetPathKey(physicalPath)]; exists {
+				return nil, fmt.Errorf("путь %s для логического файла '%s' совпадает с переопределенным путем файла '%s'",
+					physicalPath, logicalFile.LogicalName, other)
+			}
+			usedPaths[targetPathKey(physicalPath)] = logicalFile.LogicalName
+		}
+
+		targets = append(targets, RestoreFileTarget{
+			LogicalName: logicalFile.LogicalName,
+			Type:        logicalFile.Type,
+			TargetPath:  physicalPath,
+			Size:        logicalFile.Size,
+		})
+	}
+
+	return targets, nil
+}
+
+// targetPathKey - Ключ целевого пути для проверки совпадений (без учета регистра и вида разделителей)
+func targetPathKey(path string) string {
+	return strings.ToLower(strings.TrimRight(strings.ReplaceAll(path, "\\", "/"), "/"))
+}
+
+// buildMoveClause - Формирует MOVE-часть команды RESTORE по целевым путям файлов
+func buildMoveClause(targets []RestoreFileTarget) string {
+	moveParts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		moveParts = append(moveParts, fmt.Sprintf("MOVE N'%s' TO N'%s'", strings.ReplaceAll(target.LogicalName, "'", "''"), target.TargetPath))
+	}
+	return strings.Join(moveParts, ", ")
+}
+
+// buildRestoreStatements - Формирует команды RESTORE для каждого файла цепочки
+func buildRestoreStatements(chain []BackupMetadata, newDBName, backupDir, moveClause string, opts RestoreOptions, stopAt *time.Time) []string {
+	statements := make([]string, 0, len(chain))
+
+	for i, file := range chain {
+		isFirstFile := i == 0
+		isLastFile := i == len(chain)-1
+		var restoreQuery string
+
+		var recoveryOption string
+		// Номер набора в файле (файл, дописанный с NOINIT, содержит несколько наборов)
+		filePositionClause := fmt.Sprintf(", FILE = %d", file.FilePosition())
+
+		// Остановка по отметке применяется к каждому журналу (отметка может оказаться в любом из них),
+		// STOPAT - только к последнему журналу, который покрывает желаемый момент
+		var stopClause string
+		if file.Type == "Transaction Log" && (opts.hasMark() || isLastFile) {
+			stopClause = buildStopClause(opts, stopAt)
+		}
+
+		if isLastFile {
+			// Для последнего файла всегда используем RECOVERY
+			recoveryOption = "RECOVERY"
+		} else {
+			// Не последний бэкап: всегда используем NORECOVERY
+			recoveryOption = "NORECOVERY"
+		}
+
+		// Источник: все файлы бэкапа (для бэкапа, разбитого на полосы, - по одному DISK на полосу)
+		media := mediaClause(backupDir, file)
+
+		if isFirstFile {
+			// Первый файл (FULL/DIFF) использует MOVE и REPLACE
+			restoreQuery = fmt.Sprintf("RESTORE DATABASE [%s] FROM %s WITH %s, REPLACE%s, %s, STATS = 10", newDBName, media, moveClause, filePositionClause, recoveryOption)
+		} else {
+			// Последующие файлы (DIFF/TRN). MOVE не нужен.
+			switch file.Type {
+			case "Transaction Log":
+				restoreQuery = fmt.Sprintf("RESTORE LOG [%s] FROM %s WITH %s%s%s, STATS = 10", newDBName, media, recoveryOption, filePositionClause, stopClause)
+			default:
+				// Дифференциальный или полный бэкап: используем RESTORE DATABASE
+				restoreQuery = fmt.Sprintf("RESTORE DATABASE [%s] FROM %s WITH %s%s, STATS = 10", newDBName, media, recoveryOption, filePositionClause)
+			}
+		}
+
+		statements = append(statements, restoreQuery)
+	}
+
+	return statements
+}
+
+// BuildRestorePlan - Строит план восстановления без его выполнения.
+// Используется как для предпросмотра (/api/restore-plan), так и в StartRestore, чтобы выполнялось ровно то, что показано.
+func BuildRestorePlan(db *sql.DB, backupBaseName, newDBName string, opts RestoreOptions, smbSharePath string, paths RestorePaths) (*RestorePlan, error) {
+	// 1. Получение последовательности бэкапов
+	chain, err := GetRestoreSequence(db, backupBaseName, opts.RestoreTime, opts.hasMark(), smbSharePath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения последовательности бэкапов для %s: %w", backupBaseName, err)
+	}
+
+	// Бэкап более новой версии SQL Server восстановить невозможно - сообщаем об этом до запуска
+	if serverMajor, err := getServerMajorVersion(db); err != nil {
+		logging.LogError(err.Error())
+	} else if err := checkBackupVersion(chain[0], serverMajor); err != nil {
+		return nil, err
+	}
+
+	plan := &RestorePlan{
+		BackupBaseName: backupBaseName,
+		NewDBName:      newDBName,
+		RequestedTime:  opts.RestoreTime,
+	}
+
+	// 2. Определяем момент времени, который будет достигнут, и нужен ли STOPAT на последнем журнале
+	reachedTime, exactTime := resolveRestorePoint(chain, opts.RestoreTime)
+	var stopAt *time.Time
+	if opts.RestoreTime != nil && exactTime && chain[len(chain)-1].Type == "Transaction Log" {
+		stopAt = opts.RestoreTime
+	}
+	if !opts.hasMark() {
+		plan.ReachedTime = &reachedTime
+		plan.ExactTime = exactTime
+	}
+
+	// 3. Размеры файлов цепочки на диске (все полосы; файл с несколькими наборами учитывается один раз)
+	backupDir := filepath.Join(smbSharePath, backupBaseName)
+	counted := make(map[string]bool)
+	for _, file := range chain {
+		planFile := RestorePlanFile{BackupMetadata: file}
+		for _, fileName := range file.MediaFiles() {
+			info, err := os.Stat(filepath.Join(backupDir, fileName))
+			if err != nil {
+				logging.LogError(fmt.Sprintf("Ошибка получения размера файла бэкапа %s: %v", fileName, err))
+				continue
+			}
+			planFile.Size += info.Size()
+			if !counted[fileName] {
+				counted[fileName] = true
+				plan.TotalSize += info.Size()
+			}
+		}
+		plan.Files = append(plan.Files, planFile)
+	}
+
+	// 4. Логические имена файлов из первого бэкапа в цепочке для формирования MOVE
+	logicalFiles, err := GetBackupLogicalFiles(db, mediaClause(backupDir, chain[0]), chain[0].FilePosition())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения логических имен файлов бэкапа для %s: %w", backupBaseName, err)
+	}
+	logging.LogDebug(fmt.Sprintf("Успешно получены логические имена файлов из бэкапа: %+v", logicalFiles))
+
+	plan.Targets, err = buildMoveTargets(logicalFiles, newDBName, paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range plan.Targets {
+		plan.RequiredSpace += target.Size
+	}
+	moveClause := buildMoveClause(plan.Targets)
+
+	// 5. Команды RESTORE
+	plan.Statements = buildRestoreStatements(chain, newDBName, backupDir, moveClause, opts, stopAt)
+
+	return plan, nil
+}
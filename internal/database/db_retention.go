@@ -0,0 +1,342 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/config"
+	"github.com/freezzorg/SQLManager/internal/jobs"
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+)
+
+// RetentionItem - Файл бэкапа в отчете о применении политики хранения
+type RetentionItem struct {
+	FileName string    `json:"fileName"`
+	Position int       `json:"position,omitempty"` // Номер набора в файле (если наборов несколько)
+	Files    []string  `json:"files,omitempty"`    // Все файлы бэкапа, разбитого на полосы
+	Type     string    `json:"type"`
+	End      time.Time `json:"end"`
+	Size     int64     `json:"size"`
+	Reason   string    `json:"reason"` // Почему файл сохраняется или удаляется
+}
+
+// RetentionReport - Отчет о применении политики хранения к каталогу бэкапов
+type RetentionReport struct {
+	Directory  string                 `json:"directory"`
+	DryRun     bool                   `json:"dryRun"`
+	Policy     config.RetentionPolicy `json:"policy"`
+	Kept       []RetentionItem        `json:"kept"`
+	Deleted    []RetentionItem        `json:"deleted"` // При DryRun - файлы, которые будут удалены
+	FreedBytes int64                  `json:"freedBytes"`
+	Errors     []string               `json:"errors,omitempty"`
+}
+
+// backupChainUnit - Полный бэкап вместе с зависящими от него дифференциальными и журнальными бэкапами
+type backupChainUnit struct {
+	full      BackupMetadata
+	members   []BackupMetadata // Дифференциальные и журнальные бэкапы цепочки
+	latestEnd time.Time        // Окончание самого позднего бэкапа цепочки
+}
+
+// isChainBase - Проверяет, является ли полный бэкап базой для дифференциального/журнального бэкапа
+// (по DatabaseBackupLSN в пределах одного экземпляра базы)
+func isChainBase(full, dependent BackupMetadata) bool {
+	if dependent.DatabaseBackupLSN.IsZero() {
+		return false
+	}
+	if !sameBackupFamily(full, dependent) {
+		return false
+	}
+	return dependent.DatabaseBackupLSN.Compare(full.FirstLSN) == 0 ||
+		dependent.DatabaseBackupLSN.Compare(full.CheckpointLSN) == 0
+}
+
+// groupBackupChains - Разбивает бэкапы каталога на цепочки (новые первыми) и бэкапы без базового полного бэкапа
+func groupBackupChains(metadata []BackupMetadata) ([]*backupChainUnit, []BackupMetadata) {
+	var units []*backupChainUnit
+	for _, b := range metadata {
+		if b.Type == "Database" {
+			units = append(units, &backupChainUnit{full: b, latestEnd: b.End.Time})
+		}
+	}
+
+	var orphans []BackupMetadata
+	for _, b := range metadata {
+		if b.Type == "Database" {
+			continue
+		}
+		var base *backupChainUnit
+		for _, unit := range units {
+			if !isChainBase(unit.full, b) {
+				continue
+			}
+			// Предпочитаем обычный полный бэкап копии (COPY_ONLY не может быть базой цепочки)
+			if base == nil || (base.full.IsCopyOnly && !unit.full.IsCopyOnly) {
+				base = unit
+			}
+		}
+		if base == nil {
+			orphans = append(orphans, b)
+			continue
+		}
+		base.members = append(base.members, b)
+		if b.End.After(base.latestEnd) {
+			base.latestEnd = b.End.Time
+		}
+	}
+
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].full.End.AfterCT(units[j].full.End)
+	})
+	return units, orphans
+}
+
+// selectGFSBackups - Выбирает первый полный бэкап каждого из последних count периодов (неделя или месяц)
+func selectGFSBackups(units []*backupChainUnit, count int, periodKey func(time.Time) string, reasonPrefix string, reasons map[string]string) {
+	if count <= 0 {
+		return
+	}
+
+	// Первый (самый ранний) полный бэкап каждого периода
+	firstInPeriod := make(map[string]BackupMetadata)
+	for _, unit := range units {
+		key := periodKey(unit.full.End.Time)
+		if existing, ok := firstInPeriod[key]; !ok || unit.full.End.Before(existing.End.Time) {
+			firstInPeriod[key] = unit.full
+		}
+	}
+
+	periods := make([]string, 0, len(firstInPeriod))
+	for key := range firstInPeriod {
+		periods = append(periods, key)
+	}
+	// Ключи периодов сортируются лексикографически в хронологическом порядке
+	sort.Sort(sort.Reverse(sort.StringSlice(periods)))
+	if len(periods) > count {
+		periods = periods[:count]
+	}
+
+	for _, key := range periods {
+		full := firstInPeriod[key]
+		if _, kept := reasons[full.SetKey()]; !kept {
+			reasons[full.SetKey()] = fmt.Sprintf("%s %s", reasonPrefix, key)
+		}
+	}
+}
+
+// planRetention - Определяет, какие бэкапы сохраняются (причина по SetKey); остальные подлежат удалению.
+// Цепочка сохраняется целиком, поэтому полный бэкап никогда не удаляется, пока сохраняются зависящие от него файлы.
+func planRetention(metadata []BackupMetadata, policy config.RetentionPolicy, now time.Time) map[string]string {
+	reasons := make(map[string]string)
+	// Неполные бэкапы не образуют цепочек: иначе неполный полный бэкап сохранился бы как последняя цепочка
+	complete, incomplete := splitIncompleteBackups(metadata)
+	units, orphans := groupBackupChains(complete)
+
+	keepUnit := func(unit *backupChainUnit, reason string) {
+		if _, kept := reasons[unit.full.SetKey()]; !kept {
+			reasons[unit.full.SetKey()] = reason
+		}
+		for _, member := range unit.members {
+			if _, kept := reasons[member.SetKey()]; !kept {
+				reasons[member.SetKey()] = reason
+			}
+		}
+	}
+
+	// Цепочки считаются по обычным полным бэкапам; копии (COPY_ONLY) не образуют цепочек
+	// и сохраняются по сроку, GFS или если это самый свежий полный бэкап
+	regularChains := 0
+	for i, unit := range units {
+		if i == 0 {
+			keepUnit(unit, "последний полный бэкап")
+		}
+		if !unit.full.IsCopyOnly {
+			regularChains++
+			if regularChains == 1 {
+				// Самая свежая цепочка сохраняется всегда, иначе пропадет возможность восстановления на текущий момент
+				keepUnit(unit, "последняя цепочка")
+			} else if policy.KeepChains > 0 && regularChains <= policy.KeepChains {
+				keepUnit(unit, fmt.Sprintf("одна из последних %d цепочек", policy.KeepChains))
+			}
+		}
+		if policy.KeepDays > 0 && !unit.latestEnd.Before(now.AddDate(0, 0, -policy.KeepDays)) {
+			keepUnit(unit, fmt.Sprintf("цепочка покрывает последние %d дн.", policy.KeepDays))
+		}
+	}
+
+	// GFS: сохраняются только полные бэкапы, их журналы и дифференциальные бэкапы могут быть удалены
+	selectGFSBackups(units, policy.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	}, "GFS: неделя", reasons)
+	selectGFSBackups(units, policy.KeepMonthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	}, "GFS: месяц", reasons)
+
+	// Бэкапы без базового полного бэкапа сохраняются, если они не старше самой ранней сохраняемой цепочки
+	var oldestKeptStart time.Time
+	for _, unit := range units {
+		if _, kept := reasons[unit.full.SetKey()]; kept && (oldestKeptStart.IsZero() || unit.full.Start.Before(oldestKeptStart)) {
+			oldestKeptStart = unit.full.Start.Time
+		}
+	}
+	for _, orphan := range orphans {
+		if oldestKeptStart.IsZero() || !orphan.End.Before(oldestKeptStart) {
+			reasons[orphan.SetKey()] = "нет базового полного бэкапа в каталоге, новее сохраняемых цепочек"
+		}
+	}
+	for _, b := range incomplete {
+		if oldestKeptStart.IsZero() || !b.End.Before(oldestKeptStart) {
+			reasons[b.SetKey()] = fmt.Sprintf("неполный бэкап (%s), новее сохраняемых цепочек", b.IncompleteReason)
+		}
+	}
+
+	return reasons
+}
+
+// ApplyRetention - Применяет политику хранения к каталогу бэкапов. При dryRun только формирует отчет.
+// Записи об удаленных файлах удаляются из каталога бэкапов.
+func ApplyRetention(dirName string, policy config.RetentionPolicy, smbSharePath string, dryRun bool) (*RetentionReport, error) {
+	if policy.IsEmpty() {
+		return nil, fmt.Errorf("для каталога '%s' не задана политика хранения", dirName)
+	}
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+
+	if !dryRun {
+		// Каталог бэкапов называется по имени базы - не удаляем файлы во время ее бэкапа
+		owner := jobs.NewID()
+		if err := jobs.LockDatabase(dirName, owner, jobs.TypeRetention); err != nil {
+			return nil, err
+		}
+		defer jobs.UnlockDatabase(dirName, owner)
+
+		// И во время восстановления из этого каталога
+		RestoreProgressesMutex.Lock()
+		for dbName, progress := range RestoreProgresses {
+			if progress.BackupBaseName == dirName && (progress.Status == "queued" || progress.Status == "pending" || progress.Status == "in_progress") {
+				RestoreProgressesMutex.Unlock()
+				return nil, fmt.Errorf("%w: из каталога '%s' выполняется восстановление базы '%s'", jobs.ErrDatabaseBusy, dirName, dbName)
+			}
+		}
+		RestoreProgressesMutex.Unlock()
+
+		if isVerificationActive(dirName) {
+			return nil, fmt.Errorf("%w: выполняется проверка бэкапов каталога '%s'", jobs.ErrDatabaseBusy, dirName)
+		}
+	}
+
+	backupDir := filepath.Join(smbSharePath, dirName)
+	metadata, err := loadBackupMetadata(backupDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) == 0 {
+		return nil, fmt.Errorf("в каталоге '%s' нет метаданных бэкапов", dirName)
+	}
+
+	// Политика применяется к бэкапам базы каталога, как цепочки GetRestoreSequence и GetChainReport:
+	// бэкапы других баз, попавшие в каталог, не образуют цепочек и не удаляются
+	expected := expectedDatabaseName(dirName, metadata)
+	reasons := planRetention(filterBackupsByDatabase(dirName, metadata), policy, time.Now())
+	for _, b := range metadata {
+		if expected != "" && b.DatabaseName != "" && !strings.EqualFold(b.DatabaseName, expected) {
+			reasons[b.SetKey()] = fmt.Sprintf("бэкап другой базы (%s), политика хранения каталога к нему не применяется", b.DatabaseName)
+		}
+	}
+	report := &RetentionReport{
+		Directory: dirName,
+		DryRun:    dryRun,
+		Policy:    policy,
+		Kept:      []RetentionItem{},
+		Deleted:   []RetentionItem{},
+	}
+
+	// Файл удаляется, только если в нем не осталось сохраняемых наборов (файлы, дописанные с NOINIT)
+	keptFiles := make(map[string]bool)
+	for _, b := range metadata {
+		if _, kept := reasons[b.SetKey()]; kept {
+			for _, fileName := range b.MediaFiles() {
+				keptFiles[fileName] = true
+			}
+		}
+	}
+
+	var deletedFiles []string
+	counted := make(map[string]bool)
+	for _, b := range metadata {
+		item := RetentionItem{FileName: b.FileName, Type: b.Type, End: b.End.Time}
+		if b.FilePosition() > 1 {
+			item.Position = b.FilePosition()
+		}
+		if b.IsStriped() {
+			item.Files = b.MediaFiles()
+		}
+		// Размер файла учитывается в первом из его наборов
+		var removable []string
+		for _, fileName := range b.MediaFiles() {
+			if counted[fileName] {
+				continue
+			}
+			counted[fileName] = true
+			if info, err := os.Stat(filepath.Join(backupDir, fileName)); err == nil {
+				item.Size += info.Size()
+			}
+			if !keptFiles[fileName] {
+				removable = append(removable, fileName)
+			}
+		}
+
+		if reason, kept := reasons[b.SetKey()]; kept {
+			item.Reason = reason
+			report.Kept = append(report.Kept, item)
+			continue
+		}
+		if len(removable) == 0 && keptFiles[b.FileName] {
+			item.Reason = "файл содержит сохраняемые наборы бэкапа"
+			report.Kept = append(report.Kept, item)
+			continue
+		}
+
+		item.Reason = "не попадает ни под одно правило хранения"
+		if b.IsIncomplete() {
+			item.Reason = fmt.Sprintf("неполный бэкап (%s), старше сохраняемых цепочек", b.IncompleteReason)
+		}
+		if !dryRun {
+			removeFailed := false
+			for _, fileName := range removable {
+				if err := os.Remove(filepath.Join(backupDir, fileName)); err != nil && !os.IsNotExist(err) {
+					report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", fileName, err))
+					removeFailed = true
+					continue
+				}
+				deletedFiles = append(deletedFiles, fileName)
+				logging.LogInfo(fmt.Sprintf("Удален файл бэкапа по политике хранения: %s", filepath.Join(backupDir, fileName)))
+			}
+			if removeFailed {
+				continue
+			}
+		}
+		report.Deleted = append(report.Deleted, item)
+		report.FreedBytes += item.Size
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if len(deletedFiles) > 0 {
+		if err := deleteBackupMetadata(backupDir, deletedFiles...); err != nil {
+			return report, err
+		}
+	}
+	logging.LogWebInfo(fmt.Sprintf("Политика хранения применена к каталогу '%s': удалено файлов %d (%s), сохранено %d",
+		dirName, len(report.Deleted), formatBytes(report.FreedBytes), len(report.Kept)))
+	return report, nil
+}
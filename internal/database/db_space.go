@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+)
+
+// normalizeVolumePath - Приводит путь к единому виду для сравнения с точками монтирования (разделители "/", регистр для Windows)
+func normalizeVolumePath(path string) string {
+	path = strings.ReplaceAll(path, "\\", "/")
+	if len(path) >= 2 && path[1] == ':' {
+		// Пути Windows не чувствительны к регистру
+		path = strings.ToLower(path)
+	}
+	if !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// findVolume - Находит точку монтирования, на которой расположен путь (самое длинное совпадение префикса)
+func findVolume(volumes map[string]int64, path string) (string, bool) {
+	normalizedPath := normalizeVolumePath(path)
+	bestMatch := ""
+	for mountPoint := range volumes {
+		if strings.HasPrefix(normalizedPath, mountPoint) && len(mountPoint) > len(bestMatch) {
+			bestMatch = mountPoint
+		}
+	}
+	return bestMatch, bestMatch != ""
+}
+
+// getServerVolumes - Возвращает свободное место на томах сервера SQL Server (точка монтирования -> байты).
+// Используется sys.dm_os_volume_stats по файлам всех баз; для дисков Windows дополнительно
+// sys.dm_os_enumerate_fixed_drives (SQL Server 2017+) или xp_fixeddrives - в том числе диски без файлов баз.
+func getServerVolumes(db *sql.DB) (map[string]int64, error) {
+	volumes := make(map[string]int64)
+
+	query := `
+		SELECT DISTINCT vs.volume_mount_point, vs.available_bytes
+		FROM sys.master_files mf
+		CROSS APPLY sys.dm_os_volume_stats(mf.database_id, mf.file_id) vs;
+	`
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса sys.dm_os_volume_stats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mountPoint string
+		var availableBytes int64
+		if err := rows.Scan(&mountPoint, &availableBytes); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования строки sys.dm_os_volume_stats: %w", err)
+		}
+		volumes[normalizeVolumePath(mountPoint)] = availableBytes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка после итерации строк sys.dm_os_volume_stats: %w", err)
+	}
+
+	// sys.dm_os_enumerate_fixed_drives возвращает все локальные диски Windows; на Linux строк нет
+	fixedRows, err := db.Query("SELECT fixed_drive_path, free_space_in_bytes FROM sys.dm_os_enumerate_fixed_drives")
+	if err == nil {
+		defer fixedRows.Close()
+		found := false
+		for fixedRows.Next() {
+			var drivePath string
+			var freeBytes int64
+			if err := fixedRows.Scan(&drivePath, &freeBytes); err != nil {
+				continue
+			}
+			found = true
+			mountPoint := normalizeVolumePath(drivePath)
+			if _, exists := volumes[mountPoint]; !exists {
+				volumes[mountPoint] = freeBytes
+			}
+		}
+		if found {
+			return volumes, nil
+		}
+	} else {
+		logging.LogDebug(fmt.Sprintf("sys.dm_os_enumerate_fixed_drives недоступна: %v", err))
+	}
+
+	// xp_fixeddrives доступна только на Windows; на Linux ошибку игнорируем
+	driveRows, err := db.Query("EXEC master.dbo.xp_fixeddrives")
+	if err != nil {
+		logging.LogDebug(fmt.Sprintf("xp_fixeddrives недоступна: %v", err))
+		return volumes, nil
+	}
+	defer driveRows.Close()
+
+	for driveRows.Next() {
+		var drive string
+		var freeMB int64
+		if err := driveRows.Scan(&drive, &freeMB); err != nil {
+			continue
+		}
+		mountPoint := normalizeVolumePath(drive + ":/")
+		if _, exists := volumes[mountPoint]; !exists {
+			volumes[mountPoint] = freeMB * 1024 * 1024
+		}
+	}
+
+	return volumes, nil
+}
+
+// getDatabaseFileSizes - Возвращает пути и размеры (в байтах) файлов существующей базы данных
+func getDatabaseFileSizes(db *sql.DB, dbName string) (map[string]int64, error) {
+	query := fmt.Sprintf("SELECT physical_name, CAST(size AS bigint) * 8192 FROM sys.master_files WHERE database_id = DB_ID(N'%s')", dbName)
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения файлов базы данных '%s': %w", dbName, err)
+	}
+	defer rows.Close()
+
+	files := make(map[string]int64)
+	for rows.Next() {
+		var physicalName string
+		var size int64
+		if err := rows.Scan(&physicalName, &size); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования файлов базы данных '%s': %w", dbName, err)
+		}
+		files[physicalName] = size
+	}
+	return files, rows.Err()
+}
+
+// CheckRestoreFreeSpace - Проверяет, что файлы восстанавливаемой базы поместятся на целевые тома.
+// Место, занятое файлами перезаписываемой базы (RESTORE ... REPLACE), считается свободным.
+func CheckRestoreFreeSpace(db *sql.DB, plan *RestorePlan) error {
+	volumes, err := getServerVolumes(db)
+	if err != nil {
+		return err
+	}
+
+	// Необходимое место по томам
+	required := make(map[string]int64)
+	for _, target := range plan.Targets {
+		volume, ok := findVolume(volumes, target.TargetPath)
+		if !ok {
+			// Том без файлов баз (на Linux sys.dm_os_volume_stats видит только их): место проверить нельзя,
+			// поэтому восстановление не запускается, чтобы не заполнить диск посреди RESTORE
+			return fmt.Errorf("не удалось определить том для пути %s (файл %s) и проверить свободное место: "+
+				"SQL Server сообщает свободное место только для томов, на которых уже есть файлы баз", target.TargetPath, target.LogicalName)
+		}
+		required[volume] += target.Size
+	}
+
+	// Файлы существующей базы будут перезаписаны - учитываем их размер как доступный
+	existingFiles, err := getDatabaseFileSizes(db, plan.NewDBName)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка получения файлов базы '%s' для проверки места: %v", plan.NewDBName, err))
+	}
+	for physicalName, size := range existingFiles {
+		if volume, ok := findVolume(volumes, physicalName); ok {
+			volumes[volume] += size
+		}
+	}
+
+	for volume, requiredBytes := range required {
+		if requiredBytes > volumes[volume] {
+			return fmt.Errorf("недостаточно места на томе %s для восстановления базы '%s': требуется %s, доступно %s",
+				volume, plan.NewDBName, formatBytes(requiredBytes), formatBytes(volumes[volume]))
+		}
+	}
+
+	return nil
+}
+
+// getDatabaseUsedSize - Оценивает размер бэкапа базы данных: занятое место в файлах данных (full/diff)
+// или объем журнала с момента последнего бэкапа журнала (log)
+func getDatabaseUsedSize(db *sql.DB, dbName, backupType string) (int64, error) {
+	var size sql.NullInt64
+
+	if backupType == BackupTypeLog {
+		query := fmt.Sprintf("SELECT CAST(log_since_last_log_backup_mb * 1024 * 1024 AS bigint) FROM sys.dm_db_log_stats(DB_ID(N'%s'))", dbName)
+		err := db.QueryRow(query).Scan(&size)
+		if err == nil {
+			return size.Int64, nil
+		}
+		logging.LogDebug(fmt.Sprintf("sys.dm_db_log_stats недоступна для базы '%s': %v, используется размер файлов журнала", dbName, err))
+		query = fmt.Sprintf("SELECT SUM(CAST(size AS bigint)) * 8192 FROM sys.master_files WHERE database_id = DB_ID(N'%s') AND type = 1", dbName)
+		if err := db.QueryRow(query).Scan(&size); err != nil {
+			return 0, fmt.Errorf("ошибка получения размера журнала базы данных '%s': %w", dbName, err)
+		}
+		return size.Int64, nil
+	}
+
+	query := fmt.Sprintf("SELECT SUM(CAST(used_pages AS bigint)) * 8192 FROM [%s].sys.allocation_units", dbName)
+	if err := db.QueryRow(query).Scan(&size); err != nil {
+		return 0, fmt.Errorf("ошибка получения занятого места базы данных '%s': %w", dbName, err)
+	}
+	return size.Int64, nil
+}
+
+// CheckBackupFreeSpace - Проверяет, что на SMB-шаре достаточно места для бэкапа базы данных
+func CheckBackupFreeSpace(db *sql.DB, dbName, backupType, smbSharePath string) error {
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+
+	freeBytes, err := utils.FreeSpace(smbSharePath)
+	if err != nil {
+		return fmt.Errorf("ошибка получения свободного места на SMB-шаре %s: %w", smbSharePath, err)
+	}
+
+	requiredBytes, err := getDatabaseUsedSize(db, dbName, backupType)
+	if err != nil {
+		return err
+	}
+
+	if uint64(requiredBytes) > freeBytes {
+		return fmt.Errorf("недостаточно места на SMB-шаре %s для бэкапа базы '%s': требуется около %s, доступно %s",
+			smbSharePath, dbName, formatBytes(requiredBytes), formatBytes(int64(freeBytes)))
+	}
+
+	logging.LogDebug(fmt.Sprintf("Проверка места для бэкапа базы '%s': требуется %s, доступно %s", dbName, formatBytes(requiredBytes), formatBytes(int64(freeBytes))))
+	return nil
+}
+
+// formatBytes - Форматирует размер в байтах в человекочитаемый вид
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d Б", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cБ", float64(size)/float64(div), []rune("КМГТП")[exp])
+}
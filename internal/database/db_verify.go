@@ -0,0 +1,211 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/jobs"
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+)
+
+// Результаты проверки файла бэкапа (BackupMetadata.VerifyStatus); пустое значение - файл не проверялся
+const (
+	VerifyStatusOK     = "ok"
+	VerifyStatusFailed = "failed"
+)
+
+// VerifyOptions - Параметры проверки бэкапов каталога
+type VerifyOptions struct {
+	FileName    string     // Проверить только этот файл (иначе - цепочку восстановления)
+	RestoreTime *time.Time // Момент, на который строится цепочка (nil - последняя цепочка)
+	ScheduleID  string     // Идентификатор расписания, запустившего проверку
+}
+
+// verifyResult - Результат проверки одного файла
+type verifyResult struct {
+	status  string
+	message string
+	time    time.Time
+}
+
+// Каталоги, проверка которых поставлена в очередь или выполняется
+var activeVerifications = make(map[string]string)
+var activeVerificationsMutex sync.Mutex
+
+// isVerificationActive - Проверяет, выполняется ли проверка бэкапов каталога
+func isVerificationActive(dirName string) bool {
+	activeVerificationsMutex.Lock()
+	defer activeVerificationsMutex.Unlock()
+	_, active := activeVerifications[strings.ToLower(dirName)]
+	return active
+}
+
+// buildVerifyQuery - Формирует команду RESTORE VERIFYONLY для набора бэкапа (все полосы, FILE = n).
+// WITH CHECKSUM допустим только для бэкапов, созданных с контрольными суммами (иначе ошибка 3187).
+func buildVerifyQuery(backupDir string, backup BackupMetadata) string {
+	query := fmt.Sprintf("RESTORE VERIFYONLY FROM %s WITH FILE = %d", mediaClause(backupDir, backup), backup.FilePosition())
+	if backup.HasBackupChecksums {
+		query += ", CHECKSUM"
+	}
+	return query
+}
+
+// saveVerifyResults - Записывает результаты проверки в записи каталога бэкапов.
+// Изменяются только проверенные файлы, чтобы не затереть изменения, сделанные во время проверки.
+func saveVerifyResults(backupDir string, results map[string]verifyResult) error {
+	return modifyBackupMetadata(backupDir, func(metadata *BackupMetadata) bool {
+		result, ok := results[metadata.SetKey()]
+		if !ok {
+			return false
+		}
+		metadata.VerifyStatus = result.status
+		metadata.VerifyTime = &CustomTime{result.time}
+		metadata.VerifyError = result.message
+		return true
+	})
+}
+
+// StartVerify - Ставит в очередь проверку файлов бэкапа каталога командой RESTORE VERIFYONLY.
+// Проверяется цепочка, построенная GetRestoreSequence, либо один файл (opts.FileName).
+// Результат и время проверки сохраняются в записи каждого файла в каталоге бэкапов.
+func StartVerify(db *sql.DB, dirName string, opts VerifyOptions, smbSharePath string) (jobID string, err error) {
+	if err := utils.EnsureSMBMounted(smbSharePath); err != nil {
+		return "", fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", smbSharePath, err)
+	}
+	backupDir := filepath.Join(smbSharePath, dirName)
+
+	var files []BackupMetadata
+	if opts.FileName != "" {
+		metadata, err := loadBackupMetadata(backupDir)
+		if err != nil {
+			return "", err
+		}
+		// Все наборы файла (в том числе наборы, для которых файл - одна из полос)
+		for _, b := range metadata {
+			if containsString(b.MediaFiles(), opts.FileName) {
+				files = append(files, b)
+			}
+		}
+		if len(files) == 0 {
+			return "", fmt.Errorf("файл '%s' не найден в метаданных каталога '%s'", opts.FileName, dirName)
+		}
+	} else {
+		files, err = GetRestoreSequence(db, dirName, opts.RestoreTime, false, smbSharePath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	jobID = jobs.NewID()
+	key := strings.ToLower(dirName)
+	activeVerificationsMutex.Lock()
+	if owner, active := activeVerifications[key]; active {
+		activeVerificationsMutex.Unlock()
+		return "", fmt.Errorf("%w: бэкапы каталога '%s' уже проверяются (%s)", jobs.ErrDatabaseBusy, dirName, owner)
+	}
+	activeVerifications[key] = jobID
+	activeVerificationsMutex.Unlock()
+
+	finish := func() {
+		activeVerificationsMutex.Lock()
+		if activeVerifications[key] == jobID {
+			delete(activeVerifications, key)
+		}
+		activeVerificationsMutex.Unlock()
+	}
+
+	params := map[string]string{"backupBaseName": dirName}
+	if opts.FileName != "" {
+		params["fileName"] = opts.FileName
+	}
+	if opts.RestoreTime != nil {
+		params["restoreTime"] = opts.RestoreTime.Format("2006-01-02 15:04:05")
+	}
+	if opts.ScheduleID != "" {
+		params["schedule"] = opts.ScheduleID
+	}
+	chain := make([]string, len(files))
+	for i, file := range files {
+		chain[i] = file.FileName
+	}
+
+	job := &jobs.Job{
+		ID:         jobID,
+		Type:       jobs.TypeVerify,
+		Database:   dirName,
+		Parameters: params,
+		Status:     "queued",
+		StartTime:  time.Now(),
+		Chain:      chain,
+	}
+	saveJob := func() {
+		if err := jobs.Save(job); err != nil {
+			logging.LogError(fmt.Sprintf("Ошибка сохранения операции проверки бэкапов каталога '%s': %v", dirName, err))
+		}
+	}
+	saveJob()
+
+	run := func() {
+		defer finish()
+
+		job.Status = "in_progress"
+		saveJob()
+		logging.LogWebInfo(fmt.Sprintf("Начата проверка бэкапов каталога '%s', файлов: %d", dirName, len(files)))
+
+		results := make(map[string]verifyResult, len(files))
+		var failed []string
+		for i, file := range files {
+			query := buildVerifyQuery(backupDir, file)
+			logging.LogDebug(fmt.Sprintf("Выполнение RESTORE VERIFYONLY (%d/%d): %s", i+1, len(files), query))
+
+			result := verifyResult{status: VerifyStatusOK}
+			if _, err := db.Exec(query); err != nil {
+				result.status = VerifyStatusFailed
+				result.message = err.Error()
+				failed = append(failed, file.FileName)
+				logging.LogError(fmt.Sprintf("Файл бэкапа %s не прошел проверку: %v", file.FileName, err))
+			} else if !file.HasBackupChecksums {
+				result.message = "Бэкап создан без контрольных сумм, проверена только читаемость"
+			}
+			result.time = time.Now()
+			results[file.SetKey()] = result
+
+			job.Percentage = (i + 1) * 100 / len(files)
+			saveJob()
+		}
+
+		if err := saveVerifyResults(backupDir, results); err != nil {
+			logging.LogError(fmt.Sprintf("Ошибка сохранения результатов проверки каталога '%s': %v", dirName, err))
+		}
+
+		job.EndTime = time.Now()
+		if len(failed) > 0 {
+			job.Status = "failed"
+			job.Error = fmt.Sprintf("Не прошли проверку файлов: %d из %d (%s)", len(failed), len(files), strings.Join(failed, ", "))
+			logging.LogWebError(fmt.Sprintf("Проверка бэкапов каталога '%s': %s", dirName, job.Error))
+		} else {
+			job.Status = "completed"
+			logging.LogWebInfo(fmt.Sprintf("Проверка бэкапов каталога '%s' успешно завершена, файлов: %d", dirName, len(files)))
+		}
+		saveJob()
+	}
+
+	onCancel := func() {
+		job.Status = "cancelled"
+		job.Error = "Отменено пользователем до запуска"
+		job.EndTime = time.Now()
+		saveJob()
+		finish()
+		logging.LogWebInfo(fmt.Sprintf("Проверка бэкапов каталога '%s' отменена до запуска", dirName))
+	}
+
+	if position := jobs.Enqueue(jobID, jobs.TypeVerify, dirName, getServerName(db), run, onCancel); position > 0 {
+		logging.LogWebInfo(fmt.Sprintf("Проверка бэкапов каталога '%s' поставлена в очередь, позиция %d", dirName, position))
+	}
+	return jobID, nil
+}
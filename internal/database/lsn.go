@@ -0,0 +1,160 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LSN - Номер записи журнала транзакций SQL Server (log sequence number).
+// Состоит из номера виртуального файла журнала (VLF), номера блока в нем и номера записи в блоке.
+// RESTORE HEADERONLY и msdb возвращают LSN как numeric(25,0): VLF * 10^15 + блок * 10^5 + запись,
+// DBCC и функции fn_dblog - в виде "vlf:block:slot" в шестнадцатеричной записи (например, 0000002a:000001b8:0003).
+// Сравнение строк неверно для LSN разной длины, поэтому LSN сравниваются покомпонентно.
+type LSN struct {
+	VLF   uint32
+	Block uint32
+	Slot  uint16
+}
+
+// Множители компонентов LSN в десятичной форме numeric(25,0)
+const (
+	lsnSlotDigits  = 5
+	lsnBlockDigits = 10
+)
+
+// ParseLSN - Разбирает LSN в десятичной (numeric(25,0)) или шестнадцатеричной (vlf:block:slot) форме.
+// Пустая строка соответствует нулевому LSN (например, DatabaseBackupLSN первого полного бэкапа).
+func ParseLSN(s string) (LSN, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return LSN{}, nil
+	}
+	if strings.Contains(s, ":") {
+		return parseHexLSN(s)
+	}
+	return parseDecimalLSN(s)
+}
+
+// parseHexLSN - Разбирает LSN вида "vlf:block:slot" (шестнадцатеричные числа)
+func parseHexLSN(s string) (LSN, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return LSN{}, fmt.Errorf("неверный формат LSN '%s': ожидается vlf:block:slot", s)
+	}
+	vlf, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return LSN{}, fmt.Errorf("неверный номер VLF в LSN '%s': %w", s, err)
+	}
+	block, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return LSN{}, fmt.Errorf("неверный номер блока в LSN '%s': %w", s, err)
+	}
+	slot, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return LSN{}, fmt.Errorf("неверный номер записи в LSN '%s': %w", s, err)
+	}
+	return LSN{VLF: uint32(vlf), Block: uint32(block), Slot: uint16(slot)}, nil
+}
+
+// parseDecimalLSN - Разбирает LSN в форме numeric(25,0). Значение может не помещаться в uint64,
+// поэтому компоненты выделяются из строки по позициям разрядов.
+func parseDecimalLSN(s string) (LSN, error) {
+	// Значение numeric может прийти с дробной частью из нулей (например, "1454000000767000081.0")
+	if dot := strings.IndexByte(s, '.'); dot >= 0 && strings.Trim(s[dot+1:], "0") == "" {
+		s = s[:dot]
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return LSN{}, fmt.Errorf("неверный формат LSN '%s': ожидается число или vlf:block:slot", s)
+		}
+	}
+	digits := strings.TrimLeft(s, "0")
+	if len(digits) > 25 {
+		return LSN{}, fmt.Errorf("LSN '%s' длиннее 25 знаков", s)
+	}
+	digits = strings.Repeat("0", 25-len(digits)) + digits
+
+	vlfPart := digits[:25-lsnBlockDigits-lsnSlotDigits]
+	blockPart := digits[25-lsnBlockDigits-lsnSlotDigits : 25-lsnSlotDigits]
+	slotPart := digits[25-lsnSlotDigits:]
+
+	vlf, err := strconv.ParseUint(vlfPart, 10, 32)
+	if err != nil {
+		return LSN{}, fmt.Errorf("неверный номер VLF в LSN '%s': %w", s, err)
+	}
+	block, err := strconv.ParseUint(blockPart, 10, 32)
+	if err != nil {
+		return LSN{}, fmt.Errorf("неверный номер блока в LSN '%s': %w", s, err)
+	}
+	slot, err := strconv.ParseUint(slotPart, 10, 16)
+	if err != nil {
+		return LSN{}, fmt.Errorf("неверный номер записи в LSN '%s': %w", s, err)
+	}
+	return LSN{VLF: uint32(vlf), Block: uint32(block), Slot: uint16(slot)}, nil
+}
+
+// IsZero - Проверяет, что LSN не задан
+func (l LSN) IsZero() bool {
+	return l == LSN{}
+}
+
+// Compare - Сравнивает LSN численно: -1, если l < other, 0 при равенстве, 1, если l > other
+func (l LSN) Compare(other LSN) int {
+	switch {
+	case l.VLF != other.VLF:
+		return compareUint(uint64(l.VLF), uint64(other.VLF))
+	case l.Block != other.Block:
+		return compareUint(uint64(l.Block), uint64(other.Block))
+	default:
+		return compareUint(uint64(l.Slot), uint64(other.Slot))
+	}
+}
+
+// compareUint - Сравнивает два беззнаковых числа
+func compareUint(a, b uint64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// String - Возвращает LSN в десятичной форме, как в RESTORE HEADERONLY (пустая строка для нулевого LSN)
+func (l LSN) String() string {
+	if l.IsZero() {
+		return ""
+	}
+	if l.VLF == 0 {
+		return strconv.FormatUint(uint64(l.Block)*100000+uint64(l.Slot), 10)
+	}
+	return fmt.Sprintf("%d%010d%05d", l.VLF, l.Block, l.Slot)
+}
+
+// Hex - Возвращает LSN в форме vlf:block:slot
+func (l LSN) Hex() string {
+	return fmt.Sprintf("%08x:%08x:%04x", l.VLF, l.Block, l.Slot)
+}
+
+// MarshalJSON - Сохраняет LSN строкой в десятичной форме (совместимо с прежним форматом backup_metadata.json)
+func (l LSN) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.String())
+}
+
+// UnmarshalJSON - Читает LSN из строки (любой из форм) или из числа
+func (l *LSN) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), "\"")
+	if s == "null" {
+		*l = LSN{}
+		return nil
+	}
+	parsed, err := ParseLSN(s)
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
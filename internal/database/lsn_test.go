@@ -0,0 +1,192 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseLSN(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  LSN
+	}{
+		{"пустой LSN", "", LSN{}},
+		{"нулевой DatabaseBackupLSN", "0", LSN{}},
+		{"полный бэкап", "1454000000076700081", LSN{VLF: 1454, Block: 767, Slot: 81}},
+		{"журнал", "1454000000791200001", LSN{VLF: 1454, Block: 7912, Slot: 1}},
+		{"двузначный VLF", "37000000032800037", LSN{VLF: 37, Block: 328, Slot: 37}},
+		{"малая база, VLF 0", "3200001", LSN{Block: 32, Slot: 1}},
+		{"малая база, VLF 0, номер записи", "81", LSN{Slot: 81}},
+		{"после переноса журнала, VLF 99", "99000000987600001", LSN{VLF: 99, Block: 9876, Slot: 1}},
+		{"после переноса журнала, VLF 100", "100000000012800001", LSN{VLF: 100, Block: 128, Slot: 1}},
+		{"максимальная длина numeric(25,0)", "4294967295429496729565535", LSN{VLF: 4294967295, Block: 4294967295, Slot: 65535}},
+		{"ведущие нули", "000037000000032800037", LSN{VLF: 37, Block: 328, Slot: 37}},
+		{"дробная часть из нулей", "1454000000076700081.0", LSN{VLF: 1454, Block: 767, Slot: 81}},
+		{"дробная часть из нескольких нулей", "3200001.000", LSN{Block: 32, Slot: 1}},
+		{"пробелы", " 1454000000076700081 ", LSN{VLF: 1454, Block: 767, Slot: 81}},
+		{"шестнадцатеричная форма", "0000002a:000001b8:0003", LSN{VLF: 42, Block: 440, Slot: 3}},
+		{"шестнадцатеричная форма без ведущих нулей", "5ae:2ff:51", LSN{VLF: 1454, Block: 767, Slot: 81}},
+		{"шестнадцатеричная форма, верхний регистр", "000005AE:000002FF:0051", LSN{VLF: 1454, Block: 767, Slot: 81}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLSN(tt.input)
+			if err != nil {
+				t.Fatalf("ParseLSN(%q): ошибка %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLSN(%q) = %+v, ожидается %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLSNRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"не число", "abc"},
+		{"знак", "-1454000000076700081"},
+		{"ненулевая дробная часть", "1454000000076700081.5"},
+		{"две точки", "1.0.0"},
+		{"длиннее 25 знаков", "12345678901234567890123456"},
+		{"VLF больше uint32", "9999999999000000000000000"},
+		{"два компонента", "0000002a:000001b8"},
+		{"четыре компонента", "1:2:3:4"},
+		{"неверный VLF", "zz:1:1"},
+		{"неверный блок", "1::1"},
+		{"номер записи больше uint16", "1:1:10000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ParseLSN(tt.input); err == nil {
+				t.Errorf("ParseLSN(%q) = %+v, ожидается ошибка", tt.input, got)
+			}
+		})
+	}
+}
+
+func TestLSNStringRoundTrip(t *testing.T) {
+	tests := []struct {
+		lsn  LSN
+		want string
+		hex  string
+	}{
+		{LSN{}, "", "00000000:00000000:0000"},
+		{LSN{Slot: 81}, "81", "00000000:00000000:0051"},
+		{LSN{Block: 32, Slot: 1}, "3200001", "00000000:00000020:0001"},
+		{LSN{VLF: 37, Block: 328, Slot: 37}, "37000000032800037", "00000025:00000148:0025"},
+		{LSN{VLF: 1454, Block: 767, Slot: 81}, "1454000000076700081", "000005ae:000002ff:0051"},
+		{LSN{VLF: 4294967295, Block: 4294967295, Slot: 65535}, "4294967295429496729565535", "ffffffff:ffffffff:ffff"},
+	}
+	for _, tt := range tests {
+		if got := tt.lsn.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, ожидается %q", tt.lsn, got, tt.want)
+		}
+		if got := tt.lsn.Hex(); got != tt.hex {
+			t.Errorf("%+v.Hex() = %q, ожидается %q", tt.lsn, got, tt.hex)
+		}
+		for _, form := range []string{tt.lsn.String(), tt.lsn.Hex()} {
+			parsed, err := ParseLSN(form)
+			if err != nil {
+				t.Errorf("ParseLSN(%q): ошибка %v", form, err)
+				continue
+			}
+			if parsed != tt.lsn {
+				t.Errorf("ParseLSN(%q) = %+v, ожидается %+v", form, parsed, tt.lsn)
+			}
+		}
+	}
+}
+
+func TestLSNJSON(t *testing.T) {
+	type record struct {
+		FirstLSN LSN `json:"firstLSN"`
+		LastLSN  LSN `json:"lastLSN"`
+	}
+	original := record{
+		FirstLSN: LSN{VLF: 1454, Block: 767, Slot: 81},
+		LastLSN:  LSN{Block: 32, Slot: 1},
+	}
+
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if want := `{"firstLSN":"1454000000076700081","lastLSN":"3200001"}`; string(data) != want {
+		t.Errorf("json.Marshal = %s, ожидается %s", data, want)
+	}
+
+	var decoded record
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", data, err)
+	}
+	if decoded != original {
+		t.Errorf("json.Unmarshal(%s) = %+v, ожидается %+v", data, decoded, original)
+	}
+
+	// Прежний формат backup_metadata.json и значения, записанные числом или в шестнадцатеричной форме
+	tests := []struct {
+		input string
+		want  record
+	}{
+		{`{"firstLSN":"","lastLSN":null}`, record{}},
+		{`{"firstLSN":1454000000076700081,"lastLSN":3200001}`, original},
+		{`{"firstLSN":"000005ae:000002ff:0051","lastLSN":"3200001.0"}`, original},
+	}
+	for _, tt := range tests {
+		var got record
+		if err := json.Unmarshal([]byte(tt.input), &got); err != nil {
+			t.Errorf("json.Unmarshal(%s): %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %+v, ожидается %+v", tt.input, got, tt.want)
+		}
+	}
+
+	var invalid record
+	if err := json.Unmarshal([]byte(`{"firstLSN":"abc"}`), &invalid); err == nil {
+		t.Error("json.Unmarshal неверного LSN: ожидается ошибка")
+	}
+}
+
+// Первые четыре случая прежнее сравнение строк (compareLSN) упорядочивало неверно:
+// LSN разной длины после перехода журнала в VLF с большим числом разрядов и в малых базах
+func TestLSNCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want int
+	}{
+		{"перенос журнала: VLF 99 и VLF 100", "99000000987600001", "100000000012800001", -1},
+		{"VLF 9 и VLF 10", "9000000999900001", "10000000000100001", -1},
+		{"малая база: VLF 0 и VLF 1", "99900001", "1000000000100001", -1},
+		{"VLF 0: блок 9 и блок 10", "900001", "1000001", -1},
+		{"номер записи 9 и 10", "1454000000076700009", "1454000000076700010", -1},
+		{"пустой LSN меньше любого", "", "81", -1},
+		{"больший блок", "1454000000791200001", "1454000000076700081", 1},
+		{"равны в разных формах", "1454000000076700081", "000005ae:000002ff:0051", 0},
+		{"равны с дробной частью", "3200001.0", "3200001", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := ParseLSN(tt.a)
+			if err != nil {
+				t.Fatalf("ParseLSN(%q): %v", tt.a, err)
+			}
+			b, err := ParseLSN(tt.b)
+			if err != nil {
+				t.Fatalf("ParseLSN(%q): %v", tt.b, err)
+			}
+			if got := a.Compare(b); got != tt.want {
+				t.Errorf("Compare(%s, %s) = %d, ожидается %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := b.Compare(a); got != -tt.want {
+				t.Errorf("Compare(%s, %s) = %d, ожидается %d", tt.b, tt.a, got, -tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"sync"
+)
+
+// Типы событий, передаваемых подписчикам (SSE /api/events)
+const (
+	TypeJob      = "job"      // Изменение состояния операции
+	TypeProgress = "progress" // Изменение процента выполнения операции
+	TypeLog      = "log"      // Новая запись краткого лога
+)
+
+// Размер буфера канала подписчика; при переполнении события для медленного подписчика отбрасываются
+const subscriberBuffer = 64
+
+// Event - Событие для подписчиков
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+var subscribers = make(map[chan Event]struct{})
+var subscribersMutex sync.Mutex
+
+// Subscribe - Регистрирует подписчика и возвращает канал событий и функцию отписки
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	subscribersMutex.Lock()
+	subscribers[ch] = struct{}{}
+	subscribersMutex.Unlock()
+
+	unsubscribe := func() {
+		subscribersMutex.Lock()
+		if _, ok := subscribers[ch]; ok {
+			delete(subscribers, ch)
+			close(ch)
+		}
+		subscribersMutex.Unlock()
+	}
+	return ch, unsubscribe
+}
+
+// HasSubscribers - Проверяет, есть ли хотя бы один подписчик (чтобы не собирать данные впустую)
+func HasSubscribers() bool {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+	return len(subscribers) > 0
+}
+
+// Publish - Рассылает событие всем подписчикам без блокировки
+func Publish(eventType string, data interface{}) {
+	subscribersMutex.Lock()
+	defer subscribersMutex.Unlock()
+
+	for ch := range subscribers {
+		select {
+		case ch <- Event{Type: eventType, Data: data}:
+		default:
+			// Подписчик не успевает читать - пропускаем событие, он получит актуальное состояние со следующим
+		}
+	}
+}
@@ -3,17 +3,22 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/freezzorg/SQLManager/internal/config"
 	"github.com/freezzorg/SQLManager/internal/database"
+	"github.com/freezzorg/SQLManager/internal/events"
+	"github.com/freezzorg/SQLManager/internal/jobs"
 	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/scheduler"
 	"github.com/freezzorg/SQLManager/internal/utils"
 )
 
@@ -22,11 +27,36 @@ type RestoreRequest struct {
 	BackupBaseName  string `json:"backupBaseName"`  // Имя директории бэкапа (например, "Edelweis")
 	NewDBName       string `json:"newDbName"`       // Имя новой/восстанавливаемой базы
 	RestoreDateTime string `json:"restoreDateTime"` // Дата и время для PIRT (DD.MM.YYYY HH:MM:SS)
+	StopAtMark      string `json:"stopAtMark,omitempty"`     // Отметка транзакции для STOPATMARK (необязательно)
+	StopBeforeMark  string `json:"stopBeforeMark,omitempty"` // Отметка транзакции для STOPBEFOREMARK (необязательно)
+	DataPath        string            `json:"dataPath,omitempty"`      // Каталог для файлов данных вместо data_path из конфигурации
+	LogPath         string            `json:"logPath,omitempty"`       // Каталог для файлов журнала вместо log_path из конфигурации
+	FileOverrides   map[string]string `json:"fileOverrides,omitempty"` // Полный целевой путь по логическому имени файла
 }
 
 // Структура для запроса на бэкап
 type BackupRequest struct {
     DBName string `json:"dbName"` // Имя базы данных для бэкапа
+    Type   string `json:"type"`   // Тип бэкапа: full, diff, log (по умолчанию full)
+    Mode   string `json:"mode"`   // Режим: online (по умолчанию, без отключения пользователей) или exclusive (SINGLE_USER)
+    CopyOnly    *bool  `json:"copyOnly,omitempty"`    // Бэкап COPY_ONLY (по умолчанию true, чтобы не менять цепочку бэкапов)
+    Compression *bool  `json:"compression,omitempty"` // Сжатие бэкапа (по умолчанию - настройка сервера)
+    Checksum    *bool  `json:"checksum,omitempty"`    // Контрольные суммы (по умолчанию - настройка сервера)
+    Description string `json:"description,omitempty"` // Описание набора бэкапа
+}
+
+// VerifyRequest - Структура для запроса проверки бэкапов (RESTORE VERIFYONLY)
+type VerifyRequest struct {
+    BackupBaseName  string `json:"backupBaseName"`            // Каталог бэкапов
+    FileName        string `json:"fileName,omitempty"`        // Проверить только этот файл (иначе - цепочку восстановления)
+    RestoreDateTime string `json:"restoreDateTime,omitempty"` // Момент, на который строится цепочка (YYYY-MM-DD HH:MM:SS)
+}
+
+// RestoreTestRequest - Структура для запроса тестового восстановления с проверкой DBCC CHECKDB
+type RestoreTestRequest struct {
+    BackupBaseName string `json:"backupBaseName"` // Каталог бэкапов
+    DataPath       string `json:"dataPath,omitempty"` // Каталог для файлов данных временной базы (по умолчанию из конфигурации)
+    LogPath        string `json:"logPath,omitempty"`  // Каталог для файлов журнала временной базы (по умолчанию из конфигурации)
 }
 
 // AppHandlers - Структура для хранения зависимостей обработчиков, таких как *sql.DB
@@ -132,6 +162,15 @@ func (h *AppHandlers) HandleDeleteDatabase(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Удаление не допускается, пока над базой выполняется бэкап или восстановление
+	lockOwner := jobs.NewID()
+	if err := jobs.LockDatabase(dbName, lockOwner, jobs.TypeDelete); err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось удалить базу данных %s: %v", dbName, err))
+		http.Error(w, fmt.Sprintf("Ошибка удаления базы данных: %v", err), http.StatusConflict)
+		return
+	}
+	defer jobs.UnlockDatabase(dbName, lockOwner)
+
 	if err := database.DeleteDatabase(h.DB, dbName); err != nil {
 		logging.LogWebError(fmt.Sprintf("Не удалось удалить базу данных %s: %v", dbName, err))
 		http.Error(w, fmt.Sprintf("Ошибка удаления базы данных: %v", err), http.StatusInternalServerError)
@@ -211,14 +250,149 @@ func (h *AppHandlers) HandleStartRestore(w http.ResponseWriter, r *http.Request)
 		restoreTime = &t
 	}
 
-	if err := database.StartRestore(h.DB, req.BackupBaseName, req.NewDBName, restoreTime, h.AppConfig.SMBShare.LocalMountPoint, h.AppConfig.MSSQL.RestorePath); err != nil {
+	// Валидация отметок транзакций
+	if req.StopAtMark != "" && req.StopBeforeMark != "" {
+		http.Error(w, "Нельзя одновременно указывать stopAtMark и stopBeforeMark.", http.StatusBadRequest)
+		return
+	}
+	for _, mark := range []string{req.StopAtMark, req.StopBeforeMark} {
+		if mark != "" && !h.isValidMarkName(mark) {
+			logging.LogWebError(fmt.Sprintf("Недопустимое имя отметки транзакции: %s", mark))
+			http.Error(w, "Недопустимое имя отметки транзакции.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	restoreOptions := database.RestoreOptions{
+		RestoreTime:    restoreTime,
+		StopAtMark:     req.StopAtMark,
+		StopBeforeMark: req.StopBeforeMark,
+	}
+
+	// Момент восстановления должен попадать в интервалы, покрытые цепочками бэкапов
+	// (при остановке по отметке время лишь уточняет отметку и не проверяется)
+	if restoreTime != nil && req.StopAtMark == "" && req.StopBeforeMark == "" {
+		if err := database.CheckRestoreTime(req.BackupBaseName, *restoreTime, h.AppConfig.SMBShare.LocalMountPoint); err != nil {
+			logging.LogWebError(fmt.Sprintf("Восстановление базы %s отклонено: %v", req.NewDBName, err))
+			http.Error(w, fmt.Sprintf("Ошибка запуска восстановления: %v", err), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	// Валидация переопределений путей
+	restorePaths, err := h.buildRestorePaths(req.DataPath, req.LogPath, req.FileOverrides)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Недопустимые пути восстановления для базы %s: %v", req.NewDBName, err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobID, err := database.StartRestore(h.DB, req.BackupBaseName, req.NewDBName, restoreOptions, h.AppConfig.SMBShare.LocalMountPoint, restorePaths)
+	if err != nil {
 		logging.LogWebError(fmt.Sprintf("Не удалось начать восстановление базы данных %s: %v", req.NewDBName, err))
-		http.Error(w, fmt.Sprintf("Ошибка запуска восстановления: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Ошибка запуска восстановления: %v", err), jobErrorStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Восстановление базы данных '%s' из бэкапа '%s' запущено.", req.NewDBName, req.BackupBaseName)})
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Восстановление базы данных '%s' из бэкапа '%s' запущено.", req.NewDBName, req.BackupBaseName),
+		"jobId":   jobID,
+	})
+}
+
+// API для предпросмотра плана восстановления (ничего не выполняет).
+// GET - параметры в строке запроса; POST - тело как у /api/restore (RestoreRequest), в том числе fileOverrides,
+// чтобы предпросмотр показывал те же целевые пути MOVE, что будут использованы при запуске
+func (h *AppHandlers) HandleGetRestorePlan(w http.ResponseWriter, r *http.Request) {
+	var req RestoreRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req = RestoreRequest{
+			BackupBaseName:  query.Get("backup"),
+			NewDBName:       query.Get("name"),
+			RestoreDateTime: query.Get("time"),
+			StopAtMark:      query.Get("stopAtMark"),
+			StopBeforeMark:  query.Get("stopBeforeMark"),
+			DataPath:        query.Get("dataPath"),
+			LogPath:         query.Get("logPath"),
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неверный формат запроса: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := req.BackupBaseName
+	if backupBaseName == "" {
+		http.Error(w, "Имя бэкапа не указано.", http.StatusBadRequest)
+		return
+	}
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базового бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базового бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	// Имя восстанавливаемой базы нужно для MOVE; по умолчанию совпадает с именем бэкапа
+	newDBName := req.NewDBName
+	if newDBName == "" {
+		newDBName = backupBaseName
+	}
+	if !h.isValidDBName(newDBName) {
+		http.Error(w, "Недопустимое имя новой базы данных.", http.StatusBadRequest)
+		return
+	}
+
+	var restoreTime *time.Time
+	if req.RestoreDateTime != "" {
+		t, err := time.Parse("2006-01-02 15:04:05", req.RestoreDateTime)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Неверный формат даты/времени. Ожидается: YYYY-MM-DD HH:MM:SS. Ошибка: %v", err), http.StatusBadRequest)
+			return
+		}
+		restoreTime = &t
+	}
+
+	stopAtMark := req.StopAtMark
+	stopBeforeMark := req.StopBeforeMark
+	if stopAtMark != "" && stopBeforeMark != "" {
+		http.Error(w, "Нельзя одновременно указывать stopAtMark и stopBeforeMark.", http.StatusBadRequest)
+		return
+	}
+	for _, mark := range []string{stopAtMark, stopBeforeMark} {
+		if mark != "" && !h.isValidMarkName(mark) {
+			http.Error(w, "Недопустимое имя отметки транзакции.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	restoreOptions := database.RestoreOptions{
+		RestoreTime:    restoreTime,
+		StopAtMark:     stopAtMark,
+		StopBeforeMark: stopBeforeMark,
+	}
+
+	restorePaths, err := h.buildRestorePaths(req.DataPath, req.LogPath, req.FileOverrides)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := database.BuildRestorePlan(h.DB, backupBaseName, newDBName, restoreOptions, h.AppConfig.SMBShare.LocalMountPoint, restorePaths)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Не удалось построить план восстановления для бэкапа %s: %v", backupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка построения плана восстановления: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
 }
 
 // API для запуска создания бэкапа базы данных
@@ -245,14 +419,53 @@ func (h *AppHandlers) HandleStartBackup(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := database.StartBackup(h.DB, req.DBName, h.AppConfig.SMBShare.LocalMountPoint); err != nil {
+	// Валидация типа бэкапа
+	switch req.Type {
+	case "", database.BackupTypeFull, database.BackupTypeDiff, database.BackupTypeLog:
+	default:
+		http.Error(w, fmt.Sprintf("Недопустимый тип бэкапа '%s'. Допустимо: full, diff, log.", req.Type), http.StatusBadRequest)
+		return
+	}
+
+	// Валидация режима бэкапа
+	switch req.Mode {
+	case "", database.BackupModeOnline, database.BackupModeExclusive:
+	default:
+		http.Error(w, fmt.Sprintf("Недопустимый режим бэкапа '%s'. Допустимо: online, exclusive.", req.Mode), http.StatusBadRequest)
+		return
+	}
+
+	// Ручные бэкапы по умолчанию создаются как COPY_ONLY, чтобы не нарушать цепочку, используемую GetRestoreSequence
+	copyOnly := true
+	if req.CopyOnly != nil {
+		copyOnly = *req.CopyOnly
+	}
+	if len([]rune(req.Description)) > 255 {
+		http.Error(w, "Описание бэкапа не может быть длиннее 255 символов.", http.StatusBadRequest)
+		return
+	}
+
+	backupOptions := database.BackupOptions{
+		Type:        req.Type,
+		Mode:        req.Mode,
+		CopyOnly:    copyOnly,
+		Compression: req.Compression,
+		Checksum:    req.Checksum,
+		Description: req.Description,
+	}
+
+	jobID, err := database.StartBackup(h.DB, req.DBName, backupOptions, h.AppConfig.SMBShare.LocalMountPoint)
+	if err != nil {
 		logging.LogWebError(fmt.Sprintf("Не удалось начать создание бэкапа базы данных %s: %v", req.DBName, err))
-		http.Error(w, fmt.Sprintf("Ошибка запуска создания бэкапа: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Ошибка запуска создания бэкапа: %v", err), jobErrorStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Создание бэкапа базы данных '%s' запущено.", req.DBName)})
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Создание бэкапа базы данных '%s' запущено.", req.DBName),
+		"jobId":   jobID,
+	})
 }
 
 // API для отмены восстановления базы данных (УДАЛЕНИЕ БД)
@@ -276,7 +489,7 @@ func (h *AppHandlers) HandleCancelRestoreProcess(w http.ResponseWriter, r *http.
 
 	if err := database.CancelRestoreProcess(h.DB, dbName); err != nil {
 		logging.LogWebError(fmt.Sprintf("Не удалось отменить восстановление (удалить БД %s): %v", dbName, err))
-		http.Error(w, fmt.Sprintf("Ошибка отмены восстановления: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Ошибка отмены восстановления: %v", err), jobErrorStatus(err))
 		return
 	}
 
@@ -304,7 +517,7 @@ func (h *AppHandlers) HandleGetRestoreProgress(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	progress := database.GetRestoreProgress(dbName)
+	progress := database.GetRestoreProgress(h.DB, dbName)
 	if progress == nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(&database.RestoreProgress{Status: "not_found"})
@@ -345,6 +558,528 @@ func (h *AppHandlers) HandleGetBackupProgress(w http.ResponseWriter, r *http.Req
 	json.NewEncoder(w).Encode(progress)
 }
 
+// API для получения списка операций (восстановления и бэкапы) с фильтрами по базе, типу и статусу
+func (h *AppHandlers) HandleGetJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dbFilter := r.URL.Query().Get("database")
+	typeFilter := r.URL.Query().Get("type")
+	statusFilter := r.URL.Query().Get("status")
+
+	allJobs, err := database.ListJobs(h.DB)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось получить список операций: %v", err))
+		http.Error(w, "Ошибка сервера при получении списка операций", http.StatusInternalServerError)
+		return
+	}
+
+	filtered := make([]*jobs.Job, 0, len(allJobs))
+	for _, job := range allJobs {
+		if dbFilter != "" && !strings.EqualFold(job.Database, dbFilter) {
+			continue
+		}
+		if typeFilter != "" && job.Type != typeFilter {
+			continue
+		}
+		if statusFilter != "" && job.Status != statusFilter {
+			continue
+		}
+		filtered = append(filtered, job)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filtered)
+}
+
+// API для получения операции по идентификатору (/api/jobs/{id})
+func (h *AppHandlers) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Идентификатор операции не указан.", http.StatusBadRequest)
+		return
+	}
+
+	job, err := database.GetJob(h.DB, id)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось получить операцию %s: %v", id, err))
+		http.Error(w, "Ошибка сервера при получении операции", http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		http.Error(w, fmt.Sprintf("Операция '%s' не найдена.", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// API для получения очереди операций, ожидающих запуска
+func (h *AppHandlers) HandleGetQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs.ListQueue())
+}
+
+// API для перемещения операции в очереди (?id=...&position=N, позиции начинаются с 1)
+func (h *AppHandlers) HandleMoveQueued(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Идентификатор операции не указан.", http.StatusBadRequest)
+		return
+	}
+	position, err := strconv.Atoi(r.URL.Query().Get("position"))
+	if err != nil || position < 1 {
+		http.Error(w, "Неверная позиция в очереди (ожидается целое число от 1).", http.StatusBadRequest)
+		return
+	}
+
+	if err := jobs.MoveInQueue(id, position); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	logging.LogWebInfo(fmt.Sprintf("Операция %s перемещена на позицию %d в очереди", id, position))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs.ListQueue())
+}
+
+// API для отмены операции, ожидающей в очереди (?id=...)
+func (h *AppHandlers) HandleCancelQueued(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		http.Error(w, "Идентификатор операции не указан.", http.StatusBadRequest)
+		return
+	}
+
+	if err := jobs.CancelQueued(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Операция %s удалена из очереди.", id)})
+}
+
+// API для потока событий (Server-Sent Events): изменения операций, прогресс и записи краткого лога
+func (h *AppHandlers) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Потоковая передача не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	eventsCh, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent := func(eventType string, data interface{}) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			logging.LogError(fmt.Sprintf("Ошибка сериализации события %s: %v", eventType, err))
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, payload); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Начальное состояние: активные операции, чтобы клиент не ждал следующего изменения
+	if allJobs, err := database.ListJobs(h.DB); err == nil {
+		for _, job := range allJobs {
+			if job.IsActive() && !writeEvent(events.TypeJob, job) {
+				return
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-eventsCh:
+			if !ok || !writeEvent(event.Type, event.Data) {
+				return
+			}
+		case <-heartbeat.C:
+			// Комментарий SSE поддерживает соединение через прокси
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// API для списка (GET) и создания (POST) расписаний бэкапов
+func (h *AppHandlers) HandleSchedules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduler.List())
+	case http.MethodPost:
+		var schedule config.Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			http.Error(w, "Неверный формат запроса: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if schedule.ID != "" && scheduler.Exists(schedule.ID) {
+			http.Error(w, fmt.Sprintf("Расписание '%s' уже существует.", schedule.ID), http.StatusConflict)
+			return
+		}
+		h.saveSchedule(w, schedule)
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
+
+// API для изменения (PUT) и удаления (DELETE) расписания бэкапов (/api/schedules/{id})
+func (h *AppHandlers) HandleSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !scheduler.Exists(id) {
+		http.Error(w, fmt.Sprintf("Расписание '%s' не найдено.", id), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var schedule config.Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			http.Error(w, "Неверный формат запроса: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		schedule.ID = id
+		h.saveSchedule(w, schedule)
+	case http.MethodDelete:
+		if err := scheduler.Delete(id); err != nil {
+			logging.LogWebError(fmt.Sprintf("Не удалось удалить расписание %s: %v", id, err))
+			http.Error(w, fmt.Sprintf("Ошибка удаления расписания: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logging.LogWebInfo(fmt.Sprintf("Расписание '%s' удалено", id))
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": fmt.Sprintf("Расписание '%s' удалено.", id)})
+	default:
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+	}
+}
+
+// saveSchedule - Проверяет и сохраняет расписание, отвечая клиенту
+func (h *AppHandlers) saveSchedule(w http.ResponseWriter, schedule config.Schedule) {
+	if schedule.Database != "" && !h.isValidDBName(schedule.Database) {
+		http.Error(w, "Недопустимое имя базы данных.", http.StatusBadRequest)
+		return
+	}
+	if schedule.ID == "" {
+		schedule.ID = jobs.NewID()
+	}
+	if err := scheduler.Validate(schedule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := scheduler.Save(schedule); err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось сохранить расписание %s: %v", schedule.ID, err))
+		http.Error(w, fmt.Sprintf("Ошибка сохранения расписания: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logging.LogWebInfo(fmt.Sprintf("Расписание бэкапов '%s' сохранено", schedule.ID))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduler.List())
+}
+
+// API для политики хранения бэкапов каталога (?name=...): GET - отчет без удаления (dry-run),
+// POST - удаление файлов, не попадающих под политику (с параметром dryRun=true - только отчет)
+func (h *AppHandlers) HandleRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.URL.Query().Get("name")
+	if backupBaseName == "" {
+		http.Error(w, "Имя каталога бэкапов не указано.", http.StatusBadRequest)
+		return
+	}
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.Method == http.MethodGet || r.URL.Query().Get("dryRun") == "true"
+	policy := h.AppConfig.RetentionFor(backupBaseName)
+
+	report, err := database.ApplyRetention(backupBaseName, policy, h.AppConfig.SMBShare.LocalMountPoint, dryRun)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось применить политику хранения к каталогу %s: %v", backupBaseName, err))
+		status := jobErrorStatus(err)
+		if policy.IsEmpty() {
+			status = http.StatusUnprocessableEntity
+		}
+		http.Error(w, fmt.Sprintf("Ошибка применения политики хранения: %v", err), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// API для проверки файлов бэкапа командой RESTORE VERIFYONLY ... WITH CHECKSUM
+func (h *AppHandlers) HandleVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req VerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.BackupBaseName == "" {
+		http.Error(w, "Имя каталога бэкапов не указано.", http.StatusBadRequest)
+		return
+	}
+	if !h.isValidBackupBaseName(req.BackupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", req.BackupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+	if req.FileName != "" && (req.FileName != filepath.Base(req.FileName) || strings.Contains(req.FileName, "'")) {
+		http.Error(w, "Недопустимое имя файла бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	opts := database.VerifyOptions{FileName: req.FileName}
+	if req.RestoreDateTime != "" {
+		t, err := time.Parse("2006-01-02 15:04:05", req.RestoreDateTime)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Неверный формат даты/времени. Ожидается: YYYY-MM-DD HH:MM:SS. Ошибка: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.RestoreTime = &t
+	}
+
+	jobID, err := database.StartVerify(h.DB, req.BackupBaseName, opts, h.AppConfig.SMBShare.LocalMountPoint)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось начать проверку бэкапов каталога %s: %v", req.BackupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка запуска проверки бэкапов: %v", err), jobErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Проверка бэкапов каталога '%s' запущена.", req.BackupBaseName),
+		"jobId":   jobID,
+	})
+}
+
+// API для запуска тестового восстановления последней цепочки каталога во временную базу с DBCC CHECKDB
+func (h *AppHandlers) HandleStartRestoreTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RestoreTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.BackupBaseName == "" {
+		http.Error(w, "Имя каталога бэкапов не указано.", http.StatusBadRequest)
+		return
+	}
+	if !h.isValidBackupBaseName(req.BackupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", req.BackupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	restorePaths, err := h.buildRestorePaths(req.DataPath, req.LogPath, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jobID, err := database.StartRestoreTest(h.DB, req.BackupBaseName, database.RestoreTestOptions{}, h.AppConfig.SMBShare.LocalMountPoint, restorePaths)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Не удалось начать тестовое восстановление каталога %s: %v", req.BackupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка запуска тестового восстановления: %v", err), jobErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": fmt.Sprintf("Тестовое восстановление каталога '%s' запущено.", req.BackupBaseName),
+		"jobId":   jobID,
+	})
+}
+
+// API для отчета о тестовых восстановлениях: последний успешный и последний тест по каждому каталогу
+func (h *AppHandlers) HandleGetRestoreTestReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := database.GetRestoreTestReport()
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка получения отчета о тестовых восстановлениях: %v", err))
+		http.Error(w, fmt.Sprintf("Ошибка получения отчета: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// API для отчета о состоянии цепочек бэкапов каталога (/api/backups/{name}/chain-report)
+func (h *AppHandlers) HandleGetChainReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.PathValue("name")
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	report, err := database.GetChainReport(backupBaseName, h.AppConfig.SMBShare.LocalMountPoint)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Не удалось построить отчет о цепочках бэкапов %s: %v", backupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка построения отчета о цепочках: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// API для интервалов времени, на которые возможно восстановление из каталога (/api/backups/{name}/restore-windows)
+func (h *AppHandlers) HandleGetRestoreWindows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.PathValue("name")
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	windows, err := database.GetRestoreWindows(backupBaseName, h.AppConfig.SMBShare.LocalMountPoint)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Не удалось определить интервалы восстановления для бэкапа %s: %v", backupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка определения интервалов восстановления: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(windows)
+}
+
+// API для внеочередной индексации каталога бэкапов (POST /api/backups/{name}/rescan):
+// файлы, добавленные не через SQLManager (Veeam, SQL Agent), сразу становятся доступны для восстановления.
+// С параметром force=true перечитываются заголовки всех файлов каталога.
+func (h *AppHandlers) HandleRescanBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.PathValue("name")
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	result, err := database.RescanBackupDirectory(h.DB, backupBaseName, h.AppConfig.SMBShare.LocalMountPoint, force)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Ошибка индексации каталога бэкапов %s: %v", backupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка индексации каталога бэкапов: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logging.LogWebInfo(fmt.Sprintf("Каталог бэкапов '%s' проиндексирован: файлов %d, добавлено/обновлено записей %d, удалено %d",
+		backupBaseName, result.Files, result.Indexed, result.Removed))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// API для предупреждений о содержимом каталога бэкапов (/api/backups/{name}/warnings):
+// бэкапы другой базы, более новой версии SQL Server, чем целевой сервер, поврежденные бэкапы
+func (h *AppHandlers) HandleGetBackupWarnings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.PathValue("name")
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	warnings, err := database.GetBackupWarnings(h.DB, backupBaseName, h.AppConfig.SMBShare.LocalMountPoint)
+	if err != nil {
+		logging.LogError(fmt.Sprintf("Не удалось проверить содержимое каталога бэкапов %s: %v", backupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка проверки каталога бэкапов: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(warnings)
+}
+
 // API для получения краткого лога
 func (h *AppHandlers) HandleGetLog(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -376,37 +1111,148 @@ func (h *AppHandlers) HandleGetBackupMetadata(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	mountPoint := h.AppConfig.SMBShare.LocalMountPoint
-	
-	// Проверяем и монтируем SMB-шару при необходимости
-	if err := utils.EnsureSMBMounted(mountPoint); err != nil {
-		logging.LogWebError(fmt.Sprintf("Не удалось смонтировать SMB-шару %s: %v", mountPoint, err))
-		http.Error(w, "Точка монтирования SMB-шары недоступна и не удалось смонтировать.", http.StatusInternalServerError)
+	allMetadata, err := database.GetBackupMetadata(backupBaseName, h.AppConfig.SMBShare.LocalMountPoint)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Ошибка чтения метаданных каталога '%s': %v", backupBaseName, err))
+		http.Error(w, fmt.Sprintf("Ошибка чтения метаданных: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(allMetadata) == 0 {
+		http.Error(w, fmt.Sprintf("Метаданные бэкапов каталога '%s' не найдены", backupBaseName), http.StatusNotFound)
 		return
 	}
 
-	// Формируем путь к директории бэкапа
-	backupDir := filepath.Join(mountPoint, backupBaseName)
-	
-	// Проверяем существование файла метаданных
-	metadataPath := filepath.Join(backupDir, "backup_metadata.json")
+	// ?unverified=true - только файлы, не проверенные RESTORE VERIFYONLY или не прошедшие проверку
+	if r.URL.Query().Get("unverified") == "true" {
+		unverified := []database.BackupMetadata{}
+		for _, metadata := range allMetadata {
+			if metadata.VerifyStatus != database.VerifyStatusOK {
+				unverified = append(unverified, metadata)
+			}
+		}
+		allMetadata = unverified
+	}
 	
-	// Проверяем существование файла метаданных
-	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
-		http.Error(w, fmt.Sprintf("Файл метаданных %s не найден", metadataPath), http.StatusNotFound)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(allMetadata)
+}
+
+// API для поиска файлов бэкапов по каталогу бэкапов (по всем каталогам SMB-шары).
+// Параметры: directory, database, type (Database, Database Differential, Transaction Log),
+// from и to (YYYY-MM-DD HH:MM:SS) - интервал времени окончания бэкапа.
+func (h *AppHandlers) HandleGetCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
 		return
 	}
-	
-	// Читаем файл метаданных
-	data, err := os.ReadFile(metadataPath)
+
+	query := r.URL.Query()
+	filter := database.CatalogFilter{
+		Directory: query.Get("directory"),
+		Database:  query.Get("database"),
+		Type:      query.Get("type"),
+	}
+	for param, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Неверный формат параметра %s. Ожидается: YYYY-MM-DD HH:MM:SS. Ошибка: %v", param, err), http.StatusBadRequest)
+			return
+		}
+		*target = &t
+	}
+
+	entries, err := database.QueryCatalog(filter)
 	if err != nil {
-	logging.LogWebError(fmt.Sprintf("Ошибка чтения файла метаданных %s: %v", metadataPath, err))
-		http.Error(w, fmt.Sprintf("Ошибка чтения файла метаданных: %v", err), http.StatusInternalServerError)
+		logging.LogError(fmt.Sprintf("Ошибка чтения каталога бэкапов: %v", err))
+		http.Error(w, fmt.Sprintf("Ошибка чтения каталога бэкапов: %v", err), http.StatusInternalServerError)
 		return
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(data)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// API для экспорта записей каталога бэкапов в backup_metadata.json (POST /api/catalog/export?name=)
+// и импорта из него (POST /api/catalog/import?name=)
+func (h *AppHandlers) HandleCatalogTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.URL.Query().Get("name")
+	if backupBaseName == "" {
+		http.Error(w, "Имя каталога бэкапов не указано.", http.StatusBadRequest)
+		return
+	}
+	if !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	action := r.PathValue("action")
+	var count int
+	var err error
+	var description string
+	switch action {
+	case "export":
+		description = "экспорт в backup_metadata.json"
+		count, err = database.ExportCatalog(backupBaseName, h.AppConfig.SMBShare.LocalMountPoint)
+	case "import":
+		description = "импорт из backup_metadata.json"
+		count, err = database.ImportCatalog(backupBaseName, h.AppConfig.SMBShare.LocalMountPoint)
+	default:
+		http.Error(w, "Неизвестное действие с каталогом бэкапов.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Метаданные каталога '%s': %s не выполнен: %v", backupBaseName, description, err))
+		http.Error(w, fmt.Sprintf("Ошибка: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logging.LogWebInfo(fmt.Sprintf("Метаданные каталога '%s': %s выполнен, записей: %d", backupBaseName, description, count))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"directory": backupBaseName, "action": action, "count": count})
+}
+
+// API для импорта истории бэкапов из msdb сервера-источника (POST /api/catalog/msdb?name=):
+// каталог заполняется без чтения заголовков по SMB, без name - все каталоги бэкапов шары
+func (h *AppHandlers) HandleImportMSDBHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не поддерживается", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backupBaseName := r.URL.Query().Get("name")
+	if backupBaseName != "" && !h.isValidBackupBaseName(backupBaseName) {
+		logging.LogWebError(fmt.Sprintf("Недопустимое имя базы бэкапа: %s", backupBaseName))
+		http.Error(w, "Недопустимое имя базы бэкапа.", http.StatusBadRequest)
+		return
+	}
+
+	results, err := database.ImportMSDBHistory(h.DB, h.AppConfig, backupBaseName)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Ошибка импорта истории бэкапов из msdb: %v", err))
+		http.Error(w, fmt.Sprintf("Ошибка импорта истории бэкапов из msdb: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var indexed, fromHistory, removed int
+	for _, result := range results {
+		indexed += result.Indexed
+		fromHistory += result.FromHistory
+		removed += result.Removed
+	}
+	logging.LogWebInfo(fmt.Sprintf("Импорт истории бэкапов из msdb: каталогов %d, добавлено/обновлено файлов %d (из msdb %d), удалено %d",
+		len(results), indexed, fromHistory, removed))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
 }
 
 // isValidDBName - Простая валидация имени базы данных
@@ -447,3 +1293,78 @@ func (h *AppHandlers) isValidBackupBaseName(name string) bool {
 	}
 	return true
 }
+
+// buildRestorePaths - Собирает целевые пути восстановления: значения из запроса имеют приоритет над конфигурацией
+func (h *AppHandlers) buildRestorePaths(dataPath, logPath string, fileOverrides map[string]string) (database.RestorePaths, error) {
+	paths := database.RestorePaths{
+		DataPath:      h.AppConfig.RestoreDataPath(),
+		LogPath:       h.AppConfig.RestoreLogPath(),
+		FileOverrides: fileOverrides,
+	}
+	if dataPath != "" {
+		if !h.isValidRestorePath(dataPath) {
+			return paths, fmt.Errorf("недопустимый каталог для файлов данных: %s", dataPath)
+		}
+		paths.DataPath = dataPath
+	}
+	if logPath != "" {
+		if !h.isValidRestorePath(logPath) {
+			return paths, fmt.Errorf("недопустимый каталог для файлов журнала: %s", logPath)
+		}
+		paths.LogPath = logPath
+	}
+	// Совпадение путей и существование логических файлов проверяет план восстановления (buildMoveTargets)
+	for logicalName, targetPath := range fileOverrides {
+		if !h.isValidRestorePath(targetPath) {
+			return paths, fmt.Errorf("недопустимое переопределение пути для логического файла '%s': %s", logicalName, targetPath)
+		}
+	}
+	return paths, nil
+}
+
+// isValidRestorePath - Простая валидация целевого пути для файлов базы данных
+func (h *AppHandlers) isValidRestorePath(path string) bool {
+	// Путь должен быть абсолютным (Linux или Windows с буквой диска), не длиннее 260 символов
+	// и не содержать кавычек и управляющих символов, так как подставляется в команду RESTORE.
+	if len(path) == 0 || len(path) > 260 {
+		return false
+	}
+	isWindowsPath := len(path) > 2 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+	if !strings.HasPrefix(path, "/") && !isWindowsPath {
+		return false
+	}
+	for _, r := range path {
+		if r == '\'' || r == '"' || r < 32 {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidMarkName - Простая валидация имени отметки транзакции (STOPATMARK/STOPBEFOREMARK)
+func (h *AppHandlers) isValidMarkName(name string) bool {
+	// Имя отметки - имя именованной транзакции (до 128 символов) или LSN в виде "lsn:<LSN>".
+	// Допускаем буквы, цифры, подчеркивания, дефисы и двоеточие, чтобы исключить SQL-инъекции.
+	if len(name) == 0 || len(name) > 128 {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == ':') {
+			return false
+		}
+	}
+	// LSN - в десятичной форме или vlf:block:slot
+	if strings.HasPrefix(strings.ToLower(name), "lsn:") {
+		lsn, err := database.ParseLSN(name[len("lsn:"):])
+		return err == nil && !lsn.IsZero()
+	}
+	return true
+}
+
+// jobErrorStatus - HTTP-статус для ошибки запуска операции: 409, если база занята другой операцией
+func jobErrorStatus(err error) int {
+	if errors.Is(err, jobs.ErrDatabaseBusy) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrDatabaseBusy - База данных занята другой операцией (бэкап, восстановление, удаление, отмена)
+var ErrDatabaseBusy = errors.New("база данных занята другой операцией")
+
+// dbLock - Монопольная блокировка базы данных, удерживаемая одной операцией
+type dbLock struct {
+	owner     string // Идентификатор операции-владельца
+	operation string // Тип операции для сообщения об ошибке
+	count     int    // Количество повторных захватов тем же владельцем
+}
+
+var dbLocks = make(map[string]*dbLock)
+var dbLocksMutex sync.Mutex
+
+// LockDatabase - Захватывает монопольную блокировку базы данных для операции owner.
+// Повторный захват тем же владельцем допускается (например, отмена выполняющегося восстановления).
+// Если база занята другой операцией, возвращается ошибка, оборачивающая ErrDatabaseBusy.
+func LockDatabase(dbName, owner, operation string) error {
+	dbLocksMutex.Lock()
+	defer dbLocksMutex.Unlock()
+
+	// Имена баз SQL Server по умолчанию не чувствительны к регистру
+	key := strings.ToLower(dbName)
+	if lock, exists := dbLocks[key]; exists {
+		if lock.owner != owner {
+			return fmt.Errorf("%w: над базой '%s' выполняется операция %s (%s)", ErrDatabaseBusy, dbName, lock.operation, lock.owner)
+		}
+		lock.count++
+		return nil
+	}
+
+	dbLocks[key] = &dbLock{owner: owner, operation: operation, count: 1}
+	return nil
+}
+
+// UnlockDatabase - Освобождает блокировку базы данных, если она удерживается операцией owner
+func UnlockDatabase(dbName, owner string) {
+	dbLocksMutex.Lock()
+	defer dbLocksMutex.Unlock()
+
+	key := strings.ToLower(dbName)
+	lock, exists := dbLocks[key]
+	if !exists || lock.owner != owner {
+		return
+	}
+	lock.count--
+	if lock.count <= 0 {
+		delete(dbLocks, key)
+	}
+}
@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Ограничения по умолчанию, если в конфигурации не заданы
+const (
+	DefaultMaxConcurrent = 4
+	DefaultMaxPerServer  = 2
+)
+
+// QueueEntry - Операция, ожидающая запуска в очереди
+type QueueEntry struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Database    string    `json:"database"`
+	Server      string    `json:"server"`
+	Position    int       `json:"position"` // Позиция в очереди, начиная с 1
+	EnqueueTime time.Time `json:"enqueueTime"`
+}
+
+// queueItem - Элемент очереди вместе с функциями запуска и отмены
+type queueItem struct {
+	entry    QueueEntry
+	run      func() // Выполняет операцию синхронно
+	onCancel func() // Вызывается при отмене операции до запуска
+}
+
+var (
+	queueMutex    sync.Mutex
+	queueItems    []*queueItem
+	runningTotal  int
+	runningServer = make(map[string]int)
+	maxConcurrent = DefaultMaxConcurrent
+	maxPerServer  = DefaultMaxPerServer
+)
+
+// ConfigureQueue - Задает общий лимит одновременных операций и лимит на один сервер SQL (0 - значение по умолчанию)
+func ConfigureQueue(maxTotal, maxServer int) {
+	queueMutex.Lock()
+	if maxTotal > 0 {
+		maxConcurrent = maxTotal
+	}
+	if maxServer > 0 {
+		maxPerServer = maxServer
+	}
+	queueMutex.Unlock()
+
+	dispatch()
+}
+
+// Enqueue - Ставит операцию в очередь и возвращает ее позицию (0 - операция запущена сразу).
+// run выполняется в отдельной горутине, когда позволяют лимиты; onCancel - при отмене из очереди.
+func Enqueue(id, jobType, database, server string, run func(), onCancel func()) int {
+	queueMutex.Lock()
+	queueItems = append(queueItems, &queueItem{
+		entry: QueueEntry{
+			ID:          id,
+			Type:        jobType,
+			Database:    database,
+			Server:      server,
+			EnqueueTime: time.Now(),
+		},
+		run:      run,
+		onCancel: onCancel,
+	})
+	queueMutex.Unlock()
+
+	dispatch()
+	return QueuePosition(id)
+}
+
+// dispatch - Запускает операции из очереди, пока позволяют лимиты.
+// Операция, упершаяся в лимит своего сервера, не задерживает операции других серверов.
+func dispatch() {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	for i := 0; i < len(queueItems) && runningTotal < maxConcurrent; {
+		item := queueItems[i]
+		if runningServer[item.entry.Server] >= maxPerServer {
+			i++
+			continue
+		}
+
+		queueItems = append(queueItems[:i], queueItems[i+1:]...)
+		runningTotal++
+		runningServer[item.entry.Server]++
+
+		go func(item *queueItem) {
+			defer func() {
+				queueMutex.Lock()
+				runningTotal--
+				runningServer[item.entry.Server]--
+				queueMutex.Unlock()
+				dispatch()
+			}()
+			item.run()
+		}(item)
+	}
+}
+
+// QueuePosition - Возвращает позицию операции в очереди, начиная с 1 (0 - операции нет в очереди)
+func QueuePosition(id string) int {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	for i, item := range queueItems {
+		if item.entry.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// ListQueue - Возвращает операции, ожидающие запуска, в порядке очереди
+func ListQueue() []QueueEntry {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	entries := make([]QueueEntry, 0, len(queueItems))
+	for i, item := range queueItems {
+		entry := item.entry
+		entry.Position = i + 1
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// MoveInQueue - Перемещает ожидающую операцию на указанную позицию (начиная с 1)
+func MoveInQueue(id string, position int) error {
+	queueMutex.Lock()
+	index := -1
+	for i, item := range queueItems {
+		if item.entry.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		queueMutex.Unlock()
+		return fmt.Errorf("операция '%s' не найдена в очереди", id)
+	}
+
+	if position < 1 {
+		position = 1
+	}
+	if position > len(queueItems) {
+		position = len(queueItems)
+	}
+
+	item := queueItems[index]
+	queueItems = append(queueItems[:index], queueItems[index+1:]...)
+	queueItems = append(queueItems[:position-1], append([]*queueItem{item}, queueItems[position-1:]...)...)
+	queueMutex.Unlock()
+
+	// Перемещенная операция может оказаться запускаемой (другой сервер)
+	dispatch()
+	return nil
+}
+
+// CancelQueued - Удаляет операцию из очереди до ее запуска
+func CancelQueued(id string) error {
+	queueMutex.Lock()
+	var cancelled *queueItem
+	for i, item := range queueItems {
+		if item.entry.ID == id {
+			cancelled = item
+			queueItems = append(queueItems[:i], queueItems[i+1:]...)
+			break
+		}
+	}
+	queueMutex.Unlock()
+
+	if cancelled == nil {
+		return fmt.Errorf("операция '%s' не найдена в очереди", id)
+	}
+	if cancelled.onCancel != nil {
+		cancelled.onCancel()
+	}
+	return nil
+}
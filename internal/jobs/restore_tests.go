@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Имя bucket'а с результатами тестовых восстановлений
+var restoreTestsBucket = []byte("restore_tests")
+
+// RestoreTestResult - Результат тестового восстановления цепочки бэкапов с проверкой DBCC CHECKDB
+type RestoreTestResult struct {
+	JobID           string    `json:"jobId"`
+	Directory       string    `json:"directory"`               // Каталог бэкапов
+	DatabaseName    string    `json:"databaseName"`            // Временное имя восстановленной базы
+	Chain           []string  `json:"chain"`                   // Восстановленные файлы бэкапов
+	BackupSet       string    `json:"backupSet,omitempty"`     // Полный бэкап цепочки: файл (файл#номер набора) или набор полос
+	BackupFile      string    `json:"backupFile,omitempty"`    // Файл полного бэкапа цепочки
+	BackupSetGUID   string    `json:"backupSetGuid,omitempty"` // BackupSetGUID полного бэкапа (если известен)
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+	RestoreSeconds  float64   `json:"restoreSeconds"`  // Длительность восстановления
+	CheckDBSeconds  float64   `json:"checkDbSeconds"`  // Длительность DBCC CHECKDB
+	DurationSeconds float64   `json:"durationSeconds"` // Общая длительность теста
+	BackupSize      int64     `json:"backupSize"`      // Суммарный размер файлов цепочки в байтах
+	DatabaseSize    int64     `json:"databaseSize"`    // Размер файлов восстановленной базы в байтах
+	Success         bool      `json:"success"`
+	Error           string    `json:"error,omitempty"`     // Ошибка восстановления или DBCC CHECKDB
+	DropError       string    `json:"dropError,omitempty"` // Ошибка удаления временной базы
+}
+
+// SaveRestoreTestResult - Сохраняет результат тестового восстановления
+func SaveRestoreTestResult(result *RestoreTestResult) error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации результата тестового восстановления %s: %w", result.JobID, err)
+	}
+	return store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(restoreTestsBucket).Put([]byte(result.JobID), data)
+	})
+}
+
+// ListRestoreTestResults - Возвращает результаты тестовых восстановлений (новые первыми)
+func ListRestoreTestResults() ([]*RestoreTestResult, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil, nil
+	}
+
+	var results []*RestoreTestResult
+	err := store.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(restoreTestsBucket).ForEach(func(k, v []byte) error {
+			result := &RestoreTestResult{}
+			if err := json.Unmarshal(v, result); err != nil {
+				return fmt.Errorf("ошибка разбора результата тестового восстановления %s: %w", string(k), err)
+			}
+			results = append(results, result)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения результатов тестовых восстановлений: %w", err)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].StartTime.After(results[j].StartTime)
+	})
+	return results, nil
+}
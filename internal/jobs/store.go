@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/events"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Типы операций
+const (
+	TypeRestore     = "restore"
+	TypeBackup      = "backup"
+	TypeDelete      = "delete"
+	TypeRetention   = "retention"
+	TypeVerify      = "verify"
+	TypeRestoreTest = "restore_test"
+)
+
+// Имя bucket'а с записями об операциях
+var jobsBucket = []byte("jobs")
+
+// Имя bucket'а с временем последнего запуска расписаний
+var scheduleRunsBucket = []byte("schedule_runs")
+
+// Job - Запись об операции (восстановление, бэкап), сохраняемая между перезапусками службы
+type Job struct {
+	ID                      string            `json:"id"`
+	Type                    string            `json:"type"`     // restore, backup
+	Database                string            `json:"database"` // Имя базы данных, над которой выполняется операция
+	Parameters              map[string]string `json:"parameters,omitempty"`
+	Status                  string            `json:"status"` // "queued", "pending", "in_progress", "completed", "failed", "cancelled"
+	Percentage              int               `json:"percentage"`
+	QueuePosition           int               `json:"queuePosition,omitempty"`           // Позиция в очереди (для статуса "queued")
+	EstimatedCompletionTime *time.Time        `json:"estimatedCompletionTime,omitempty"` // Ожидаемое завершение текущей команды (только в памяти)
+	StartTime               time.Time         `json:"startTime"`
+	EndTime                 time.Time         `json:"endTime"`
+	UpdateTime              time.Time         `json:"updateTime"`
+	Error                   string            `json:"error,omitempty"`
+	Chain                   []string          `json:"chain,omitempty"` // Файлы бэкапов, использованные при восстановлении
+}
+
+// IsActive - Проверяет, выполняется ли операция (не завершена)
+func (j *Job) IsActive() bool {
+	return j.Status == "queued" || j.Status == "pending" || j.Status == "in_progress"
+}
+
+var store *bolt.DB
+var storeMutex sync.Mutex
+
+// SetupStore - Открывает (или создает) файл хранилища операций
+func SetupStore(path string) error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("ошибка открытия хранилища операций %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{jobsBucket, scheduleRunsBucket, restoreTestsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return fmt.Errorf("ошибка инициализации хранилища операций %s: %w", path, err)
+	}
+
+	store = db
+	return nil
+}
+
+// CloseStore - Закрывает хранилище операций
+func CloseStore() error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	err := store.Close()
+	store = nil
+	return err
+}
+
+// NewID - Генерирует уникальный идентификатор операции
+func NewID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand не должен возвращать ошибку; на всякий случай используем время
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), hex.EncodeToString(buf))
+}
+
+// Save - Сохраняет (создает или обновляет) запись об операции и оповещает подписчиков об изменении.
+// Если хранилище не открыто, только оповещает.
+func Save(job *Job) error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	job.UpdateTime = time.Now()
+	events.Publish(events.TypeJob, *job)
+
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации операции %s: %w", job.ID, err)
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+// Get - Возвращает запись об операции по идентификатору (nil, если не найдена)
+func Get(id string) (*Job, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil, nil
+	}
+
+	var job *Job
+	err := store.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		job = &Job{}
+		return json.Unmarshal(data, job)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения операции %s: %w", id, err)
+	}
+	return job, nil
+}
+
+// List - Возвращает все записи об операциях, отсортированные по времени начала (новые первыми)
+func List() ([]*Job, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil, nil
+	}
+
+	var jobs []*Job
+	err := store.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			job := &Job{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return fmt.Errorf("ошибка разбора операции %s: %w", string(k), err)
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения хранилища операций: %w", err)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartTime.After(jobs[j].StartTime)
+	})
+	return jobs, nil
+}
+
+// SaveScheduleRun - Сохраняет время последнего запуска расписания
+func SaveScheduleRun(scheduleID string, runTime time.Time) error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	return store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduleRunsBucket).Put([]byte(scheduleID), []byte(runTime.Format(time.RFC3339)))
+	})
+}
+
+// LastScheduleRun - Возвращает время последнего запуска расписания (нулевое время, если запусков не было)
+func LastScheduleRun(scheduleID string) (time.Time, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return time.Time{}, nil
+	}
+
+	var lastRun time.Time
+	err := store.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(scheduleRunsBucket).Get([]byte(scheduleID))
+		if data == nil {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, string(data))
+		if err != nil {
+			return err
+		}
+		lastRun = t
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ошибка чтения времени запуска расписания %s: %w", scheduleID, err)
+	}
+	return lastRun, nil
+}
+
+// DeleteScheduleRun - Удаляет сведения о запусках расписания
+func DeleteScheduleRun(scheduleID string) error {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	return store.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduleRunsBucket).Delete([]byte(scheduleID))
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/freezzorg/SQLManager/internal/config"
+	"github.com/freezzorg/SQLManager/internal/events"
 )
 
 var fileLogger *log.Logger
@@ -67,6 +68,9 @@ func recordBriefLog(message string) {
     }
     fullHistoryLog = append(fullHistoryLog, entry)
 
+    // Оповещаем подписчиков SSE о новой записи
+    events.Publish(events.TypeLog, entry)
+
     // Ограничение размера полного лога (500 записей)
     if len(fullHistoryLog) > 500 {
         fullHistoryLog = fullHistoryLog[len(fullHistoryLog)-500:]
@@ -0,0 +1,373 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freezzorg/SQLManager/internal/config"
+	"github.com/freezzorg/SQLManager/internal/database"
+	"github.com/freezzorg/SQLManager/internal/jobs"
+	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/utils"
+	"github.com/robfig/cron/v3"
+)
+
+// Интервал проверки расписаний
+const tickInterval = 30 * time.Second
+
+// Запуск считается пропущенным, если опоздал больше чем на это время (простой службы)
+const missedRunGrace = 2 * time.Minute
+
+// ScheduleInfo - Расписание вместе со временем последнего и следующего запуска
+type ScheduleInfo struct {
+	config.Schedule
+	LastRun *time.Time `json:"lastRun,omitempty"`
+	NextRun *time.Time `json:"nextRun,omitempty"`
+}
+
+var (
+	schedulerMutex sync.Mutex
+	appDB          *sql.DB
+	appConfig      *config.Config
+	lastRuns       = make(map[string]time.Time)
+)
+
+// Start - Запускает планировщик бэкапов по расписаниям из конфигурации
+func Start(db *sql.DB, cfg *config.Config) {
+	schedulerMutex.Lock()
+	appDB = db
+	appConfig = cfg
+	for _, schedule := range cfg.Schedules {
+		if err := Validate(schedule); err != nil {
+			logging.LogError(fmt.Sprintf("Расписание '%s' некорректно и не будет выполняться: %v", schedule.ID, err))
+		}
+		lastRun, err := jobs.LastScheduleRun(schedule.ID)
+		if err != nil {
+			logging.LogError(err.Error())
+		}
+		lastRuns[schedule.ID] = lastRun
+	}
+	schedulerMutex.Unlock()
+
+	logging.LogInfo(fmt.Sprintf("Планировщик запущен, расписаний: %d", len(cfg.Schedules)))
+
+	go func() {
+		checkSchedules(time.Now())
+		ticker := time.NewTicker(tickInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			checkSchedules(now)
+		}
+	}()
+}
+
+// Validate - Проверяет корректность расписания
+func Validate(schedule config.Schedule) error {
+	if schedule.ID == "" {
+		return fmt.Errorf("не указан идентификатор расписания")
+	}
+	if _, err := cron.ParseStandard(schedule.Cron); err != nil {
+		return fmt.Errorf("неверное cron-выражение '%s': %w", schedule.Cron, err)
+	}
+	if (schedule.Database == "") == (schedule.Pattern == "") {
+		return fmt.Errorf("должно быть указано либо имя базы (database), либо шаблон имен (pattern)")
+	}
+	if schedule.Pattern != "" {
+		if _, err := path.Match(schedule.Pattern, ""); err != nil {
+			return fmt.Errorf("неверный шаблон имен баз '%s': %w", schedule.Pattern, err)
+		}
+	}
+	switch schedule.Action {
+	case "", config.ScheduleActionBackup, config.ScheduleActionVerify, config.ScheduleActionRestoreTest:
+	default:
+		return fmt.Errorf("недопустимое действие '%s' (допустимо: backup, verify, restore_test)", schedule.Action)
+	}
+	switch schedule.Type {
+	case "", database.BackupTypeFull, database.BackupTypeDiff, database.BackupTypeLog:
+	default:
+		return fmt.Errorf("недопустимый тип бэкапа '%s' (допустимо: full, diff, log)", schedule.Type)
+	}
+	switch schedule.Mode {
+	case "", database.BackupModeOnline, database.BackupModeExclusive:
+	default:
+		return fmt.Errorf("недопустимый режим бэкапа '%s' (допустимо: online, exclusive)", schedule.Mode)
+	}
+	switch schedule.MissedPolicy {
+	case "", config.MissedRunSkip, config.MissedRunCatchUp:
+	default:
+		return fmt.Errorf("недопустимая политика пропущенных запусков '%s' (допустимо: skip, catch_up)", schedule.MissedPolicy)
+	}
+	if len([]rune(schedule.Description)) > 255 {
+		return fmt.Errorf("описание бэкапа не может быть длиннее 255 символов")
+	}
+	return nil
+}
+
+// checkSchedules - Запускает бэкапы по наступившим расписаниям
+func checkSchedules(now time.Time) {
+	schedulerMutex.Lock()
+	var due []config.Schedule
+	for _, schedule := range appConfig.Schedules {
+		if schedule.Disabled {
+			continue
+		}
+		cronSchedule, err := cron.ParseStandard(schedule.Cron)
+		if err != nil {
+			continue
+		}
+
+		lastRun, known := lastRuns[schedule.ID]
+		if !known || lastRun.IsZero() {
+			// Новое расписание: отсчет ведем с текущего момента, без немедленного запуска
+			lastRuns[schedule.ID] = now
+			saveLastRun(schedule.ID, now)
+			continue
+		}
+
+		nextRun := cronSchedule.Next(lastRun)
+		if nextRun.After(now) {
+			continue
+		}
+
+		lastRuns[schedule.ID] = now
+		saveLastRun(schedule.ID, now)
+
+		if now.Sub(nextRun) > missedRunGrace && schedule.MissedPolicy != config.MissedRunCatchUp {
+			logging.LogWebInfo(fmt.Sprintf("Расписание '%s': запуск %s пропущен (служба была недоступна)", schedule.ID, nextRun.Format("2006-01-02 15:04:05")))
+			continue
+		}
+		if now.Sub(nextRun) > missedRunGrace {
+			logging.LogWebInfo(fmt.Sprintf("Расписание '%s': выполняется пропущенный запуск %s", schedule.ID, nextRun.Format("2006-01-02 15:04:05")))
+		}
+		due = append(due, schedule)
+	}
+	schedulerMutex.Unlock()
+
+	for _, schedule := range due {
+		runSchedule(schedule)
+	}
+}
+
+// saveLastRun - Сохраняет время последнего запуска расписания в хранилище
+func saveLastRun(scheduleID string, runTime time.Time) {
+	if err := jobs.SaveScheduleRun(scheduleID, runTime); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка сохранения времени запуска расписания '%s': %v", scheduleID, err))
+	}
+}
+
+// scheduleTargets - Определяет базы данных (для проверки - каталоги бэкапов), к которым применяется расписание
+func scheduleTargets(schedule config.Schedule) ([]string, error) {
+	if schedule.Database != "" {
+		return []string{schedule.Database}, nil
+	}
+
+	if schedule.Action == config.ScheduleActionVerify || schedule.Action == config.ScheduleActionRestoreTest {
+		return backupDirTargets(schedule.Pattern)
+	}
+
+	databases, err := database.GetDatabases(appDB)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	for _, db := range databases {
+		if db.State != "online" {
+			continue
+		}
+		if matched, _ := path.Match(strings.ToLower(schedule.Pattern), strings.ToLower(db.Name)); matched {
+			targets = append(targets, db.Name)
+		}
+	}
+	return targets, nil
+}
+
+// backupDirTargets - Возвращает каталоги бэкапов на SMB-шаре, подходящие под шаблон
+func backupDirTargets(pattern string) ([]string, error) {
+	mountPoint := appConfig.SMBShare.LocalMountPoint
+	if err := utils.EnsureSMBMounted(mountPoint); err != nil {
+		return nil, fmt.Errorf("не удалось смонтировать SMB-шару %s: %w", mountPoint, err)
+	}
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения каталога %s: %w", mountPoint, err)
+	}
+
+	var targets []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(entry.Name())); matched {
+			targets = append(targets, entry.Name())
+		}
+	}
+	return targets, nil
+}
+
+// runVerifySchedule - Ставит в очередь проверку цепочек бэкапов всех каталогов расписания
+func runVerifySchedule(schedule config.Schedule, targets []string) {
+	for _, dirName := range targets {
+		jobID, err := database.StartVerify(appDB, dirName, database.VerifyOptions{ScheduleID: schedule.ID}, appConfig.SMBShare.LocalMountPoint)
+		if err != nil {
+			logging.LogWebError(fmt.Sprintf("Расписание '%s': не удалось запустить проверку бэкапов каталога '%s': %v", schedule.ID, dirName, err))
+			continue
+		}
+		logging.LogWebInfo(fmt.Sprintf("Расписание '%s': проверка бэкапов каталога '%s' запущена (операция %s)", schedule.ID, dirName, jobID))
+	}
+}
+
+// runRestoreTestSchedule - Ставит в очередь тестовые восстановления всех каталогов расписания
+func runRestoreTestSchedule(schedule config.Schedule, targets []string) {
+	paths := database.RestorePaths{
+		DataPath: appConfig.RestoreDataPath(),
+		LogPath:  appConfig.RestoreLogPath(),
+	}
+	for _, dirName := range targets {
+		jobID, err := database.StartRestoreTest(appDB, dirName, database.RestoreTestOptions{ScheduleID: schedule.ID}, appConfig.SMBShare.LocalMountPoint, paths)
+		if err != nil {
+			logging.LogWebError(fmt.Sprintf("Расписание '%s': не удалось запустить тестовое восстановление каталога '%s': %v", schedule.ID, dirName, err))
+			continue
+		}
+		logging.LogWebInfo(fmt.Sprintf("Расписание '%s': тестовое восстановление каталога '%s' запущено (операция %s)", schedule.ID, dirName, jobID))
+	}
+}
+
+// runSchedule - Ставит в очередь бэкапы всех баз расписания; результат попадает в краткий лог и историю операций
+func runSchedule(schedule config.Schedule) {
+	targets, err := scheduleTargets(schedule)
+	if err != nil {
+		logging.LogWebError(fmt.Sprintf("Расписание '%s': не удалось определить базы данных: %v", schedule.ID, err))
+		return
+	}
+	if len(targets) == 0 {
+		logging.LogWebInfo(fmt.Sprintf("Расписание '%s': нет баз данных, подходящих под шаблон '%s'", schedule.ID, schedule.Pattern))
+		return
+	}
+	switch schedule.Action {
+	case config.ScheduleActionVerify:
+		runVerifySchedule(schedule, targets)
+		return
+	case config.ScheduleActionRestoreTest:
+		runRestoreTestSchedule(schedule, targets)
+		return
+	}
+
+	opts := database.BackupOptions{
+		Type:        schedule.Type,
+		Mode:        schedule.Mode,
+		CopyOnly:    schedule.CopyOnly,
+		Compression: schedule.Compression,
+		Checksum:    schedule.Checksum,
+		Description: schedule.Description,
+		ScheduleID:  schedule.ID,
+	}
+
+	for _, dbName := range targets {
+		jobID, err := database.StartBackup(appDB, dbName, opts, appConfig.SMBShare.LocalMountPoint)
+		if err != nil {
+			logging.LogWebError(fmt.Sprintf("Расписание '%s': не удалось запустить бэкап базы '%s': %v", schedule.ID, dbName, err))
+			continue
+		}
+		logging.LogWebInfo(fmt.Sprintf("Расписание '%s': бэкап базы '%s' запущен (операция %s)", schedule.ID, dbName, jobID))
+	}
+}
+
+// List - Возвращает расписания со временем последнего и следующего запуска
+func List() []ScheduleInfo {
+	schedulerMutex.Lock()
+	defer schedulerMutex.Unlock()
+
+	infos := make([]ScheduleInfo, 0, len(appConfig.Schedules))
+	for _, schedule := range appConfig.Schedules {
+		info := ScheduleInfo{Schedule: schedule}
+		if lastRun := lastRuns[schedule.ID]; !lastRun.IsZero() {
+			info.LastRun = &lastRun
+			if cronSchedule, err := cron.ParseStandard(schedule.Cron); err == nil && !schedule.Disabled {
+				nextRun := cronSchedule.Next(lastRun)
+				info.NextRun = &nextRun
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Save - Создает расписание или заменяет существующее с тем же ID и сохраняет конфигурацию
+func Save(schedule config.Schedule) error {
+	if schedule.ID == "" {
+		schedule.ID = jobs.NewID()
+	}
+	if err := Validate(schedule); err != nil {
+		return err
+	}
+
+	schedulerMutex.Lock()
+	defer schedulerMutex.Unlock()
+
+	previous := append([]config.Schedule(nil), appConfig.Schedules...)
+	replaced := false
+	for i := range appConfig.Schedules {
+		if appConfig.Schedules[i].ID == schedule.ID {
+			appConfig.Schedules[i] = schedule
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		appConfig.Schedules = append(appConfig.Schedules, schedule)
+	}
+
+	if err := appConfig.SaveSchedules(); err != nil {
+		appConfig.Schedules = previous
+		return fmt.Errorf("ошибка сохранения конфигурации: %w", err)
+	}
+	return nil
+}
+
+// Exists - Проверяет, существует ли расписание с указанным ID
+func Exists(id string) bool {
+	schedulerMutex.Lock()
+	defer schedulerMutex.Unlock()
+
+	for _, schedule := range appConfig.Schedules {
+		if schedule.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete - Удаляет расписание и сохраняет конфигурацию
+func Delete(id string) error {
+	schedulerMutex.Lock()
+	defer schedulerMutex.Unlock()
+
+	previous := append([]config.Schedule(nil), appConfig.Schedules...)
+	index := -1
+	for i, schedule := range appConfig.Schedules {
+		if schedule.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return fmt.Errorf("расписание '%s' не найдено", id)
+	}
+
+	appConfig.Schedules = append(appConfig.Schedules[:index:index], appConfig.Schedules[index+1:]...)
+	if err := appConfig.SaveSchedules(); err != nil {
+		appConfig.Schedules = previous
+		return fmt.Errorf("ошибка сохранения конфигурации: %w", err)
+	}
+
+	delete(lastRuns, id)
+	if err := jobs.DeleteScheduleRun(id); err != nil {
+		logging.LogError(fmt.Sprintf("Ошибка удаления сведений о запусках расписания '%s': %v", id, err))
+	}
+	return nil
+}
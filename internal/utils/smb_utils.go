@@ -65,3 +65,12 @@ func EnsureSMBMounted(mountPoint string) error {
 	// Если шара не смонтирована, пытаемся смонтировать
 	return MountSMBShare(mountPoint)
 }
+
+// FreeSpace - Возвращает свободное место (в байтах), доступное непривилегированному пользователю, на томе с указанным путем
+func FreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("ошибка syscall.Statfs для %s: %w", path, err)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
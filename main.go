@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
+	"time"
 
 	"github.com/freezzorg/SQLManager/internal/config"
+	"github.com/freezzorg/SQLManager/internal/database"
 	"github.com/freezzorg/SQLManager/internal/handlers"
+	"github.com/freezzorg/SQLManager/internal/jobs"
 	"github.com/freezzorg/SQLManager/internal/logging"
+	"github.com/freezzorg/SQLManager/internal/scheduler"
 
 	// Используем стандартный драйвер для MSSQL
 	_ "github.com/denisenkom/go-mssqldb"
@@ -24,6 +29,21 @@ func main() {
 
     // 2. Настройка логирования в файл
     logging.SetupLogger(appConfig.App.LogFile, appConfig.App.LogLevel)
+
+    // Хранилище операций располагается рядом с лог-файлом
+    jobsStorePath := filepath.Join(filepath.Dir(appConfig.App.LogFile), "jobs.db")
+    if err := jobs.SetupStore(jobsStorePath); err != nil {
+        logging.LogError(fmt.Sprintf("Ошибка открытия хранилища операций: %v", err))
+    }
+    defer jobs.CloseStore()
+
+    // Каталог файлов бэкапов (метаданные всех каталогов SMB-шары) - там же
+    catalogPath := filepath.Join(filepath.Dir(appConfig.App.LogFile), "catalog.db")
+    if err := database.SetupCatalog(catalogPath); err != nil {
+        logging.LogError(fmt.Sprintf("Ошибка открытия каталога бэкапов, используются файлы backup_metadata.json: %v", err))
+    }
+    defer database.CloseCatalog()
+    jobs.ConfigureQueue(appConfig.App.MaxConcurrentJobs, appConfig.App.MaxJobsPerServer)
     
     // 3. Установка подключения к MSSQL
     db, err := setupDBConnection(appConfig.MSSQL)
@@ -35,6 +55,24 @@ func main() {
     logging.LogInfo("Успешное подключение к SQL Server.")
     defer db.Close() // Закрываем соединение при завершении работы приложения
 
+    // Сверяем операции, прерванные предыдущим перезапуском службы
+    if err := database.ReconcileJobs(db); err != nil {
+        logging.LogError(fmt.Sprintf("Ошибка сверки сохраненных операций: %v", err))
+    }
+
+    // Запуск бэкапов по расписаниям из конфигурации
+    scheduler.Start(db, appConfig)
+
+    // Фоновая индексация файлов бэкапов, добавленных на SMB-шару не через SQLManager
+    indexInterval, err := appConfig.CatalogIndexInterval()
+    if err != nil {
+        logging.LogError(fmt.Sprintf("Ошибка конфигурации индексатора, используется период %s: %v", indexInterval, err))
+    }
+    database.StartIndexer(db, appConfig, indexInterval)
+
+    // Рассылка прогресса операций подписчикам /api/events
+    database.StartProgressPublisher(db, 2*time.Second)
+
     // 4. Запуск веб-сервера
     startWebServer(db, appConfig, appConfig.App.BindAddress)
 }
@@ -46,6 +84,8 @@ func setupDBConnection(cfg struct {
 	User     string    `yaml:"user"`
 	Password string    `yaml:"password"`
 	RestorePath string `yaml:"restore_path"` 
+	DataPath    string `yaml:"data_path"`
+	LogPath     string `yaml:"log_path"`
 }) (*sql.DB, error) {
 	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", cfg.Server, cfg.User, cfg.Password, cfg.Port)
 
@@ -74,12 +114,32 @@ func startWebServer(db *sql.DB, appConfig *config.Config, addr string) {
     http.HandleFunc("/api/delete", appHandlers.AuthMiddleware(appHandlers.HandleDeleteDatabase)) 
     http.HandleFunc("/api/backups", appHandlers.AuthMiddleware(appHandlers.HandleGetBackups))
     http.HandleFunc("/api/restore", appHandlers.AuthMiddleware(appHandlers.HandleStartRestore)) 
+    http.HandleFunc("/api/restore-plan", appHandlers.AuthMiddleware(appHandlers.HandleGetRestorePlan))
     http.HandleFunc("/api/log", appHandlers.AuthMiddleware(appHandlers.HandleGetLog))
     http.HandleFunc("/api/cancel-restore", appHandlers.AuthMiddleware(appHandlers.HandleCancelRestoreProcess)) 
     http.HandleFunc("/api/restore-progress", appHandlers.AuthMiddleware(appHandlers.HandleGetRestoreProgress))
     http.HandleFunc("/api/backup", appHandlers.AuthMiddleware(appHandlers.HandleStartBackup))
     http.HandleFunc("/api/backup-progress", appHandlers.AuthMiddleware(appHandlers.HandleGetBackupProgress))
     http.HandleFunc("/api/backup-metadata", appHandlers.AuthMiddleware(appHandlers.HandleGetBackupMetadata))
+    http.HandleFunc("/api/catalog", appHandlers.AuthMiddleware(appHandlers.HandleGetCatalog))
+    http.HandleFunc("/api/catalog/msdb", appHandlers.AuthMiddleware(appHandlers.HandleImportMSDBHistory))
+    http.HandleFunc("/api/catalog/{action}", appHandlers.AuthMiddleware(appHandlers.HandleCatalogTransfer))
+    http.HandleFunc("/api/jobs", appHandlers.AuthMiddleware(appHandlers.HandleGetJobs))
+    http.HandleFunc("/api/jobs/{id}", appHandlers.AuthMiddleware(appHandlers.HandleGetJob))
+    http.HandleFunc("/api/queue", appHandlers.AuthMiddleware(appHandlers.HandleGetQueue))
+    http.HandleFunc("/api/queue/move", appHandlers.AuthMiddleware(appHandlers.HandleMoveQueued))
+    http.HandleFunc("/api/queue/cancel", appHandlers.AuthMiddleware(appHandlers.HandleCancelQueued))
+    http.HandleFunc("/api/events", appHandlers.AuthMiddleware(appHandlers.HandleEvents))
+    http.HandleFunc("/api/schedules", appHandlers.AuthMiddleware(appHandlers.HandleSchedules))
+    http.HandleFunc("/api/schedules/{id}", appHandlers.AuthMiddleware(appHandlers.HandleSchedule))
+    http.HandleFunc("/api/retention", appHandlers.AuthMiddleware(appHandlers.HandleRetention))
+    http.HandleFunc("/api/verify", appHandlers.AuthMiddleware(appHandlers.HandleVerify))
+    http.HandleFunc("/api/backups/{name}/chain-report", appHandlers.AuthMiddleware(appHandlers.HandleGetChainReport))
+    http.HandleFunc("/api/backups/{name}/warnings", appHandlers.AuthMiddleware(appHandlers.HandleGetBackupWarnings))
+    http.HandleFunc("/api/backups/{name}/rescan", appHandlers.AuthMiddleware(appHandlers.HandleRescanBackups))
+    http.HandleFunc("/api/backups/{name}/restore-windows", appHandlers.AuthMiddleware(appHandlers.HandleGetRestoreWindows))
+    http.HandleFunc("/api/restore-tests", appHandlers.AuthMiddleware(appHandlers.HandleStartRestoreTest))
+    http.HandleFunc("/api/restore-tests/report", appHandlers.AuthMiddleware(appHandlers.HandleGetRestoreTestReport))
 
     logging.LogInfo(fmt.Sprintf("Веб-сервер запущен на %s", addr))
     // Запускаем веб-сервер
@@ -497,6 +497,7 @@ document.addEventListener('DOMContentLoaded', () => {
     const restoreProgressPollingInterval = 3000; // Интервал опроса прогресса в мс
     const activeRestorePollers = {}; // Хранит setInterval ID для каждой восстанавливаемой БД
     const activeBackupPollers = {}; // Хранит setInterval ID для каждой бэкапируемой БД
+    let eventsConnected = false; // Подключен ли поток событий /api/events
     const inProgressRestores = new Set(); // Хранит имена баз, которые находятся в процессе восстановления
 
     // --- Утилиты ---
@@ -1034,8 +1035,53 @@ document.addEventListener('DOMContentLoaded', () => {
         restoreDatetimeInput.focus();
     });
 
+    // Функция для загрузки интервалов, на которые возможно восстановление (с учетом непрерывности цепочки журналов)
+    const loadRestoreWindows = async (selectedBackup) => {
+        const restoreWindowsDiv = document.getElementById('restore-windows');
+        restoreWindowsDiv.textContent = '';
+        try {
+            const response = await makeApiRequest(`/api/backups/${encodeURIComponent(selectedBackup)}/restore-windows`);
+            if (!response.ok) {
+                return;
+            }
+            const windows = await response.json();
+            // Формат времени в ответе: YYYY-MM-DDTHH:MM:SS
+            const formatWindowTime = (value) => {
+                const [date, time] = value.split('T');
+                const [yyyy, mm, dd] = date.split('-');
+                return `${dd}.${mm}.${yyyy} ${time}`;
+            };
+            const parts = windows.map(window => window.from === window.to
+                ? formatWindowTime(window.from)
+                : `${formatWindowTime(window.from)} - ${formatWindowTime(window.to)}`);
+            restoreWindowsDiv.textContent = parts.length > 0
+                ? `Доступно для восстановления: ${parts.join('; ')}`
+                : 'Нет интервалов, на которые возможно восстановление';
+        } catch (error) {
+            console.error('Ошибка при загрузке интервалов восстановления:', error);
+        }
+    };
+
+    // Функция для загрузки предупреждений о каталоге бэкапов (бэкапы другой базы, более новой версии SQL Server)
+    const loadBackupWarnings = async (selectedBackup) => {
+        const backupWarningsDiv = document.getElementById('backup-warnings');
+        backupWarningsDiv.textContent = '';
+        try {
+            const response = await makeApiRequest(`/api/backups/${encodeURIComponent(selectedBackup)}/warnings`);
+            if (!response.ok) {
+                return;
+            }
+            const warnings = await response.json();
+            backupWarningsDiv.textContent = warnings.map(warning => `⚠ ${warning}`).join('\n');
+        } catch (error) {
+            console.error('Ошибка при загрузке предупреждений о каталоге бэкапов:', error);
+        }
+    };
+
     // Функция для загрузки и отображения дат окончания бэкапов
     const loadBackupEndTimes = async (selectedBackup) => {
+        loadRestoreWindows(selectedBackup);
+        loadBackupWarnings(selectedBackup);
         try {
             const response = await makeApiRequest(`/api/backup-metadata?name=${encodeURIComponent(selectedBackup)}`);
             
@@ -1090,7 +1136,19 @@ document.addEventListener('DOMContentLoaded', () => {
                     }
                     
                     option.value = formatDateTime(date, 'input');
-                    option.textContent = `${day}.${month}.${year} ${hours}:${minutes}:${seconds}  - ${backupType}`;
+                    // Результат проверки RESTORE VERIFYONLY
+                    let verifyMark = '';
+                    if (item.VerifyStatus === 'failed') {
+                        verifyMark = ' (не прошел проверку)';
+                        option.title = item.VerifyError || '';
+                    }
+                    if (item.IsDamaged) {
+                        verifyMark += ' (поврежден)';
+                    }
+                    if (item.DatabaseName && item.DatabaseName.toLowerCase() !== selectedBackup.toLowerCase()) {
+                        verifyMark += ` (база ${item.DatabaseName})`;
+                    }
+                    option.textContent = `${day}.${month}.${year} ${hours}:${minutes}:${seconds}  - ${backupType}${verifyMark}`;
                     option.className = backupClass;
                     backupEndTimesSelect.appendChild(option);
                 });
@@ -1243,7 +1301,10 @@ document.addEventListener('DOMContentLoaded', () => {
         if (activeRestorePollers[dbName]) {
             return;
         }
-        activeRestorePollers[dbName] = setInterval(() => fetchRestoreProgress(dbName), restoreProgressPollingInterval);
+        activeRestorePollers[dbName] = setInterval(() => {
+            // При активном потоке событий опрос не нужен - он остается запасным вариантом
+            if (!eventsConnected) fetchRestoreProgress(dbName);
+        }, restoreProgressPollingInterval);
     };
 
     const fetchRestoreProgress = async (dbName) => {
@@ -1298,10 +1359,17 @@ document.addEventListener('DOMContentLoaded', () => {
         const progressBarFill = li.querySelector('.progress-fill');
         const progressText = li.querySelector('.progress-text');
 
-        if (progress.status === 'in_progress' || progress.status === 'pending') {
+        if (progress.status === 'queued') {
+            progressContainer.style.display = 'none';
+            statusIconSpan.innerHTML = `<i class="fas fa-hourglass-half" title="В очереди на восстановление (позиция ${progress.queuePosition || '?'})"></i>`;
+            statusIconSpan.title = "queued";
+        } else if (progress.status === 'in_progress' || progress.status === 'pending') {
             progressContainer.style.display = 'flex';
             progressBarFill.style.width = `${progress.percentage}%`;
             progressText.textContent = `${progress.percentage}%`;
+            progressText.title = progress.estimatedCompletionTime
+                ? `Ожидаемое завершение текущей команды: ${formatDateTime(new Date(progress.estimatedCompletionTime), 'log')}`
+                : '';
             statusIconSpan.innerHTML = `<i class="fas fa-sync-alt fa-spin restoring" title="Восстанавливается"></i>`;
             statusIconSpan.title = "restoring";
         } else {
@@ -1338,7 +1406,9 @@ document.addEventListener('DOMContentLoaded', () => {
         if (activeBackupPollers[dbName]) {
             return;
         }
-        activeBackupPollers[dbName] = setInterval(() => fetchBackupProgress(dbName), restoreProgressPollingInterval);
+        activeBackupPollers[dbName] = setInterval(() => {
+            if (!eventsConnected) fetchBackupProgress(dbName);
+        }, restoreProgressPollingInterval);
     };
 
     const fetchBackupProgress = async (dbName) => {
@@ -1349,7 +1419,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
                 updateBackupProgressDisplay(dbName, progress);
 
-                if (progress.status === 'completed' || progress.status === 'failed' || progress.status === 'not_found') {
+                if (progress.status === 'completed' || progress.status === 'failed' || progress.status === 'cancelled' || progress.status === 'not_found') {
                     let statusMessage = '';
                     switch (progress.status) {
                         case 'completed':
@@ -1358,6 +1428,9 @@ document.addEventListener('DOMContentLoaded', () => {
                         case 'failed':
                             statusMessage = 'завершено с ошибкой';
                             break;
+                        case 'cancelled':
+                            statusMessage = 'отменено';
+                            break;
                         case 'not_found':
                             statusMessage = 'не найдено (возможно, уже завершено)';
                             break;
@@ -1394,10 +1467,17 @@ document.addEventListener('DOMContentLoaded', () => {
         const progressBarFill = li.querySelector('.progress-fill');
         const progressText = li.querySelector('.progress-text');
 
-        if (progress.status === 'in_progress' || progress.status === 'pending') {
+        if (progress.status === 'queued') {
+            progressContainer.style.display = 'none';
+            statusIconSpan.innerHTML = `<i class="fas fa-hourglass-half" title="В очереди на бэкап (позиция ${progress.queuePosition || '?'})"></i>`;
+            statusIconSpan.title = "queued";
+        } else if (progress.status === 'in_progress' || progress.status === 'pending') {
             progressContainer.style.display = 'flex';
             progressBarFill.style.width = `${progress.percentage}%`;
             progressText.textContent = `${progress.percentage}%`;
+            progressText.title = progress.estimatedCompletionTime
+                ? `Ожидаемое завершение текущей команды: ${formatDateTime(new Date(progress.estimatedCompletionTime), 'log')}`
+                : '';
             statusIconSpan.innerHTML = `<i class="fas fa-save fa-spin backing-up" title="Создается бэкап"></i>`;
             statusIconSpan.title = "backing_up";
         } else {
@@ -1413,6 +1493,10 @@ document.addEventListener('DOMContentLoaded', () => {
                     iconClass = 'fas fa-exclamation-triangle error';
                     title = 'Ошибка бэкапа';
                     break;
+                case 'cancelled':
+                    iconClass = 'fas fa-times-circle offline';
+                    title = 'Бэкап отменен';
+                    break;
                 case 'not_found':
                     return;
                 default:
@@ -1424,8 +1508,44 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     };
 
+    // --- Поток событий (SSE): мгновенные обновления прогресса и краткого лога ---
+
+    const connectEvents = () => {
+        if (!window.EventSource) return;
+
+        const source = new EventSource('/api/events');
+        source.onopen = () => { eventsConnected = true; };
+        // EventSource переподключается сам, до этого работает опрос
+        source.onerror = () => { eventsConnected = false; };
+
+        const handleJobEvent = (event) => {
+            const job = JSON.parse(event.data);
+            const finished = ['completed', 'failed', 'cancelled'].includes(job.status);
+            if (job.type === 'restore') {
+                // Итоговое состояние запрашиваем через API, чтобы записать результат в лог и остановить опрос
+                if (finished) fetchRestoreProgress(job.database); else updateRestoreProgressDisplay(job.database, job);
+            } else if (job.type === 'backup') {
+                if (finished) fetchBackupProgress(job.database); else updateBackupProgressDisplay(job.database, job);
+            }
+        };
+        source.addEventListener('job', handleJobEvent);
+        source.addEventListener('progress', handleJobEvent);
+
+        source.addEventListener('log', (event) => {
+            const entry = JSON.parse(event.data);
+            const li = document.createElement('li');
+            const time = formatDateTime(new Date(entry.timestamp), 'log');
+            li.textContent = `${time} ${entry.message}`;
+            briefLog.prepend(li);
+            while (briefLog.children.length > 100) {
+                briefLog.removeChild(briefLog.lastChild);
+            }
+        });
+    };
+
     // --- Инициализация ---
     fetchDatabases();
     fetchBackups();
     fetchBriefLog();
+    connectEvents();
 });